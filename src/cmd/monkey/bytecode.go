@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey/compiler"
+	"monkey/vm"
+	"path/filepath"
+	"strings"
+)
+
+// bytecodeExt is the file extension of compiled scripts.
+const bytecodeExt = ".mkc"
+
+// compileCommand implements `monkey compile [-o out.mkc] [-strip] <file>`.
+func compileCommand(argv []string, stdin io.Reader, stderr io.Writer) int {
+	flags := flag.NewFlagSet("compile", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	output := flags.String("o", "", "output `file`, defaults to the input with a "+bytecodeExt+" extension")
+	strip := flags.Bool("strip", false, "leave out function names and line tables")
+	if err := flags.Parse(argv); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		io.WriteString(stderr, usage)
+		return exitUsage
+	}
+
+	filename := flags.Arg(0)
+	src, err := readSource(filename, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+
+	out := *output
+	if out == "" {
+		if filename == "-" {
+			fmt.Fprintf(stderr, "monkey: -o is required when compiling stdin\n")
+			return exitUsage
+		}
+		out = strings.TrimSuffix(filename, filepath.Ext(filename)) + bytecodeExt
+	}
+	if filename == "-" {
+		filename = "<stdin>"
+	}
+
+	bc, ok := compileSource(filename, string(src), stderr)
+	if !ok {
+		return exitError
+	}
+
+	var buf bytes.Buffer
+	err = compiler.WriteBytecode(&buf, bc, !*strip)
+	if err == nil {
+		err = ioutil.WriteFile(out, buf.Bytes(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+
+	return exitOK
+}
+
+// execCommand implements `monkey exec <file.mkc> [args...]`.
+func execCommand(argv []string, stdin io.Reader, stderr io.Writer) int {
+	if len(argv) < 1 {
+		io.WriteString(stderr, usage)
+		return exitUsage
+	}
+
+	filename := argv[0]
+	data, err := readSource(filename, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+
+	bc, err := compiler.ReadBytecode(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s: %s\n", filename, err)
+		return exitError
+	}
+	err = vm.Verify(bc)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s: %s\n", filename, err)
+		return exitError
+	}
+
+	return runBytecode(bc, argv[1:], stderr)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileAndExec(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "script.mk")
+	err := ioutil.WriteFile(src, []byte(`
+let check = fn(n) { if (n != 2) { 1 + true } };
+check(len(args));
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	code := compileCommand([]string{src}, strings.NewReader(""), &stderr)
+	if code != exitOK {
+		t.Fatalf("compile failed with %d: %s", code, stderr.String())
+	}
+
+	out := filepath.Join(dir, "script.mkc")
+	if _, err := os.Stat(out); err != nil {
+		t.Fatalf("no bytecode written: %s", err)
+	}
+
+	code = execCommand([]string{out, "a", "b"}, strings.NewReader(""), &stderr)
+	if code != exitOK {
+		t.Errorf("exec failed with %d: %s", code, stderr.String())
+	}
+
+	code = execCommand([]string{out, "a"}, strings.NewReader(""), &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	if !strings.Contains(stderr.String(), "at check (") {
+		t.Errorf("traceback lost the debug info: %q", stderr.String())
+	}
+}
+
+func TestCompileStripped(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.mkc")
+
+	var stderr bytes.Buffer
+	code := compileCommand([]string{"-strip", "-o", out, "-"}, strings.NewReader(`let f = fn() { 1 + true }; f();`), &stderr)
+	if code != exitOK {
+		t.Fatalf("compile failed with %d: %s", code, stderr.String())
+	}
+
+	code = execCommand([]string{out}, strings.NewReader(""), &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	if !strings.Contains(stderr.String(), "at <anonymous> (ip=") {
+		t.Errorf("traceback has debug info: %q", stderr.String())
+	}
+}
+
+func TestExecRejectsSource(t *testing.T) {
+	var stderr bytes.Buffer
+	code := execCommand([]string{"-"}, strings.NewReader(`puts(1)`), &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	if !strings.Contains(stderr.String(), "not a monkey bytecode file") {
+		t.Errorf("wrong error: %q", stderr.String())
+	}
+}
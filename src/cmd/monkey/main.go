@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"monkey/repl"
+	"os"
+	"os/user"
+)
+
+const usage = `Usage:
+  monkey                       start the REPL
+  monkey run <file> [args...]  run a script, "-" reads it from stdin
+  monkey compile [-o out.mkc] [-strip] <file>
+                               compile a script to bytecode
+  monkey exec <file.mkc> [args...]
+                               run a compiled script
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+
+	switch os.Args[1] {
+	case "run":
+		os.Exit(runCommand(os.Args[2:], os.Stdin, os.Stderr))
+	case "compile":
+		os.Exit(compileCommand(os.Args[2:], os.Stdin, os.Stderr))
+	case "exec":
+		os.Exit(execCommand(os.Args[2:], os.Stdin, os.Stderr))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		io.WriteString(os.Stderr, usage)
+		os.Exit(exitUsage)
+	}
+}
+
+func startRepl() {
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
+		user.Username)
+	fmt.Printf("Feel free to type in commands\n")
+	repl.Start(os.Stdin, os.Stdout)
+}
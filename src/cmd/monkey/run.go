@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+)
+
+// exit codes of the monkey command
+const (
+	exitOK    = 0
+	exitError = 1 // the script failed to parse, compile or run
+	exitUsage = 2 // bad command line
+)
+
+// runCommand implements `monkey run <file> [args...]`. The script arguments
+// are available to the program as the global array `args`.
+func runCommand(argv []string, stdin io.Reader, stderr io.Writer) int {
+	if len(argv) < 1 {
+		io.WriteString(stderr, usage)
+		return exitUsage
+	}
+
+	filename := argv[0]
+	src, err := readSource(filename, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %s\n", err)
+		return exitError
+	}
+	if filename == "-" {
+		filename = "<stdin>"
+	}
+
+	return runSource(filename, string(src), argv[1:], stderr)
+}
+
+func readSource(filename string, stdin io.Reader) ([]byte, error) {
+	if filename == "-" {
+		return ioutil.ReadAll(stdin)
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func runSource(filename, src string, args []string, stderr io.Writer) int {
+	bc, ok := compileSource(filename, src, stderr)
+	if !ok {
+		return exitError
+	}
+	return runBytecode(bc, args, stderr)
+}
+
+// argsGlobal is the global index of `args`, the first global defined by
+// compileSource. Bytecode read from a file relies on it, too.
+const argsGlobal = 0
+
+// compileSource parses and compiles a script, errors are reported to stderr.
+func compileSource(filename, src string, stderr io.Writer) (*compiler.Bytecode, bool) {
+	l := lexer.NewWithFilename(filename, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		for _, err := range p.Errors() {
+			fmt.Fprintf(stderr, "%s\n", err)
+		}
+		return nil, false
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+	symbolTable.Define("args")
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	err := comp.Compile(program)
+	if err != nil {
+		fmt.Fprintf(stderr, "compile error: %s\n", err)
+		return nil, false
+	}
+
+	return comp.Bytecode(), true
+}
+
+// runBytecode runs a compiled script with `args` bound to the script arguments.
+func runBytecode(bc *compiler.Bytecode, args []string, stderr io.Writer) int {
+	globals := make([]object.Object, argsGlobal+1)
+	globals[argsGlobal] = stringArray(args)
+
+	machine := vm.NewWithGlobalsStore(bc, globals)
+	err := machine.Run()
+	if err != nil {
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			io.WriteString(stderr, runtimeErr.Traceback())
+		} else {
+			fmt.Fprintf(stderr, "runtime error: %s\n", err)
+		}
+		return exitError
+	}
+
+	return exitOK
+}
+
+func stringArray(values []string) *object.Array {
+	elements := make([]object.Object, len(values))
+	for i, v := range values {
+		elements[i] = &object.String{Value: v}
+	}
+	return &object.Array{Elements: elements}
+}
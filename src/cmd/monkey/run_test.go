@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		source       string
+		args         []string
+		expectedCode int
+		expectedErr  string
+	}{
+		{`let x = 1 + 2;`, nil, exitOK, ""},
+		{`if (len(args) != 2) { 1 + true }`, []string{"a", "b"}, exitOK, ""},
+		{`if (len(args[0]) != 3) { 1 + true }`, []string{"abc"}, exitOK, ""},
+		{`let = 1;`, nil, exitError, "script.mk:1:5: expected next token to be IDENT, got = instead"},
+		{`x;`, nil, exitError, "script.mk:1:1: undefined variable x"},
+		{`1 + true;`, nil, exitError, "runtime error: unsupported types for binary operation: INTEGER BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "script.mk")
+		err := ioutil.WriteFile(path, []byte(tt.source), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stderr bytes.Buffer
+		argv := append([]string{path}, tt.args...)
+		code := runCommand(argv, strings.NewReader(""), &stderr)
+
+		if code != tt.expectedCode {
+			t.Errorf("%q: wrong exit code. want=%d, got=%d (%s)", tt.source, tt.expectedCode, code, stderr.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedErr) {
+			t.Errorf("%q: wrong error output. want=%q, got=%q", tt.source, tt.expectedErr, stderr.String())
+		}
+	}
+}
+
+func TestRunCommandStdin(t *testing.T) {
+	var stderr bytes.Buffer
+	code := runCommand([]string{"-"}, strings.NewReader("let x = ;"), &stderr)
+	if code != exitError {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitError, code)
+	}
+	if !strings.HasPrefix(stderr.String(), "<stdin>:1:9:") {
+		t.Errorf("error does not name stdin. got=%q", stderr.String())
+	}
+}
+
+func TestRunCommandUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	code := runCommand(nil, strings.NewReader(""), &stderr)
+	if code != exitUsage {
+		t.Errorf("wrong exit code. want=%d, got=%d", exitUsage, code)
+	}
+}
@@ -49,6 +49,24 @@ const (
 
 	OpClosure
 	OpGetFree
+	OpCurrentClosure
+
+	OpIterInit
+	OpIterNext
+
+	OpSetFree
+	OpSetIndex
+	OpCaptureLocal
+	OpCaptureFree
+
+	OpMod
+	OpGreaterThanOrEqual
+
+	OpWide
+
+	OpThrow
+
+	OpIn
 )
 
 type Definition struct {
@@ -104,6 +122,55 @@ var definitions = map[Opcode]*Definition{
 	// 1 byte-wide: free variable count
 	OpClosure: {"OpClosure", []int{2, 1}},
 	OpGetFree: {"OpGetFree", []int{1}},
+
+	// push the closure being executed, used for self-references in recursive functions
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	// replace the iterable on top of the stack with an iterator over it
+	OpIterInit: {"OpIterInit", []int{}},
+	// pop an iterator and push its next element, or jump to the operand when it is exhausted
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	OpSetFree: {"OpSetFree", []int{1}},
+	// pop value, index and container, store the value and push it back
+	OpSetIndex: {"OpSetIndex", []int{}},
+
+	// push the cell of a local (boxing the local first) or of a free variable,
+	// used to build the free variables of an OpClosure
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+
+	OpMod: {"OpMod", []int{}},
+	// "a <= b" is compiled as "b >= a", like "<" is compiled to OpGreaterThan
+	OpGreaterThanOrEqual: {"OpGreaterThanOrEqual", []int{}},
+
+	// prefix doubling the operand widths of the following instruction, used
+	// when an operand does not fit, e.g. a function with more than 255 locals
+	OpWide: {"OpWide", []int{}},
+
+	// pop a value and raise it as an exception, see HandlerTable
+	OpThrow: {"OpThrow", []int{}},
+
+	// pop a container and a value, push whether the value is in the container
+	OpIn: {"OpIn", []int{}},
+}
+
+// wideDefinitions holds the operand widths after OpWide of the opcodes that
+// may follow it. Jumps and globals cannot be widened.
+var wideDefinitions = map[Opcode]*Definition{}
+
+func init() {
+	for _, op := range []Opcode{
+		OpConstant, OpArray, OpHash, OpCall, OpGetLocal, OpSetLocal, OpGetBuiltin,
+		OpClosure, OpGetFree, OpSetFree, OpCaptureLocal, OpCaptureFree,
+	} {
+		def := definitions[op]
+		widths := make([]int, len(def.OperandWidths))
+		for i, w := range def.OperandWidths {
+			widths[i] = 2 * w
+		}
+		wideDefinitions[op] = &Definition{def.Name, widths}
+	}
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -115,12 +182,83 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// LookupWide returns the definition of op when it follows OpWide.
+func LookupWide(op byte) (*Definition, error) {
+	def, ok := wideDefinitions[Opcode(op)]
+	if !ok {
+		if _, err := Lookup(op); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("opcode %s cannot follow OpWide", definitions[Opcode(op)].Name)
+	}
+
+	return def, nil
+}
+
+// Make encodes op with operands, truncating operands that do not fit.
+// Use Encode when they may not.
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
+	return makeInstruction(op, def, operands)
+}
+
+// MakeWide encodes the wide form of op: OpWide followed by op with operands
+// twice as wide as Make writes them.
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := wideDefinitions[op]
+	if !ok {
+		return []byte{}
+	}
+	return append([]byte{byte(OpWide)}, makeInstruction(op, def, operands)...)
+}
+
+// Encode returns the shortest encoding of op with operands, the wide form
+// if one of them does not fit the normal one. It fails if the operands do
+// not fit either.
+func Encode(op Opcode, operands ...int) ([]byte, error) {
+	def, ok := definitions[op]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	if len(operands) != len(def.OperandWidths) {
+		return nil, fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(operands))
+	}
+	if fits(def, operands) {
+		return makeInstruction(op, def, operands), nil
+	}
+
+	wide, ok := wideDefinitions[op]
+	if ok && fits(wide, operands) {
+		return MakeWide(op, operands...), nil
+	}
+	if ok {
+		def = wide
+	}
+	for i, operand := range operands {
+		if max := maxOperand(def.OperandWidths[i]); operand < 0 || operand > max {
+			return nil, fmt.Errorf("operand %d of %s does not fit, the maximum is %d", operand, def.Name, max)
+		}
+	}
+	return nil, fmt.Errorf("%s operands %v do not fit", def.Name, operands)
+}
+
+func fits(def *Definition, operands []int) bool {
+	for i, operand := range operands {
+		if operand < 0 || operand > maxOperand(def.OperandWidths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func maxOperand(width int) int {
+	return 1<<(8*uint(width)) - 1
+}
 
+func makeInstruction(op Opcode, def *Definition, operands []int) []byte {
 	instructionLen := 1
 	for _, w := range def.OperandWidths {
 		instructionLen += w
@@ -133,6 +271,8 @@ func Make(op Opcode, operands ...int) []byte {
 		width := def.OperandWidths[i]
 
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(operand))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(operand))
 		case 1:
@@ -145,6 +285,16 @@ func Make(op Opcode, operands ...int) []byte {
 }
 
 func (ins Instructions) String() string {
+	return ins.disassemble(nil)
+}
+
+// StringWithLines disassembles the instructions like String, appending the
+// source position of each instruction taken from lt.
+func (ins Instructions) StringWithLines(lt LineTable) string {
+	return ins.disassemble(lt)
+}
+
+func (ins Instructions) disassemble(lt LineTable) string {
 	var out bytes.Buffer
 
 	i := 0
@@ -152,12 +302,28 @@ func (ins Instructions) String() string {
 		def, err := Lookup(ins[i])
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR:%s\n", err)
-			continue
+			break
+		}
+
+		// the wide form is printed on one line, at the offset of its OpWide
+		prefix, start := "", i
+		if Opcode(ins[i]) == OpWide && i+1 < len(ins) {
+			def, err = LookupWide(ins[i+1])
+			if err != nil {
+				fmt.Fprintf(&out, "ERROR:%s\n", err)
+				break
+			}
+			prefix = "OpWide "
+			i++
 		}
 
 		// 针对一个opcode，读取对应的操作数
 		operands, read := ReadOperands(def, ins[i+1:])
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		if lt != nil {
+			fmt.Fprintf(&out, "%04d %-24s ; %s\n", start, prefix+ins.fmtInstruction(def, operands), lt.PosFor(start))
+		} else {
+			fmt.Fprintf(&out, "%04d %s\n", start, prefix+ins.fmtInstruction(def, operands))
+		}
 
 		// 下一个操作符位置 = 当前操作符位置 + 1 + 读取的操作数bytes数
 		i += 1 + read
@@ -190,6 +356,8 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 
 	for i, width := range def.OperandWidths {
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -201,6 +369,10 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
@@ -208,3 +380,33 @@ func ReadUint16(ins Instructions) uint16 {
 func ReadUint8(ins Instructions) uint8 {
 	return uint8(ins[0])
 }
+
+// StackEffect returns how many values op with operands pops from the stack
+// and how many it pushes. OpIterNext only pushes when it does not jump.
+func StackEffect(op Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull,
+		OpGetGlobal, OpGetLocal, OpGetBuiltin, OpGetFree,
+		OpCurrentClosure, OpCaptureLocal, OpCaptureFree:
+		return 0, 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod,
+		OpEqual, OpNotEqual, OpGreaterThan, OpGreaterThanOrEqual,
+		OpIndex, OpIn:
+		return 2, 1
+	case OpMinus, OpBang, OpIterInit, OpIterNext:
+		return 1, 1
+	case OpPop, OpJumpNotTruthy, OpSetGlobal, OpSetLocal,
+		OpSetFree, OpReturnValue, OpThrow:
+		return 1, 0
+	case OpSetIndex:
+		return 3, 1
+	case OpArray, OpHash:
+		return operands[0], 1
+	case OpCall:
+		return operands[0] + 1, 1
+	case OpClosure:
+		return operands[1], 1
+	default:
+		return 0, 0
+	}
+}
@@ -1,6 +1,7 @@
 package code
 
 import (
+	"monkey/token"
 	"testing"
 )
 
@@ -37,6 +38,8 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
 		Make(OpClosure, 65535, 255),
+		MakeWide(OpGetLocal, 256),
+		MakeWide(OpClosure, 65536, 300),
 	}
 
 	expected := `0000 OpAdd
@@ -44,6 +47,8 @@ func TestInstructionsString(t *testing.T) {
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpWide OpGetLocal 256
+0017 OpWide OpClosure 65536 300
 `
 	concatenated := Instructions{}
 	for _, ins := range instructions {
@@ -57,6 +62,48 @@ func TestInstructionsString(t *testing.T) {
 
 }
 
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpClosure, []int{1, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 0}},
+		{OpJump, []int{65535}, []byte{byte(OpJump), 255, 255}},
+	}
+
+	for _, tt := range tests {
+		instruction, err := Encode(tt.op, tt.operands...)
+		if err != nil {
+			t.Fatalf("Encode(%d, %v) failed: %s", tt.op, tt.operands, err)
+		}
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("Encode(%d, %v) = %v, want %v", tt.op, tt.operands, instruction, tt.expected)
+		}
+	}
+
+	errorTests := []struct {
+		op       Opcode
+		operands []int
+		expected string
+	}{
+		{OpGetLocal, []int{65536}, "operand 65536 of OpGetLocal does not fit, the maximum is 65535"},
+		{OpJump, []int{65536}, "operand 65536 of OpJump does not fit, the maximum is 65535"},
+		{OpGetGlobal, []int{-1}, "operand -1 of OpGetGlobal does not fit, the maximum is 65535"},
+		{OpCall, []int{}, "OpCall takes 1 operands, got 0"},
+	}
+
+	for _, tt := range errorTests {
+		_, err := Encode(tt.op, tt.operands...)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -88,3 +135,43 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestLineTable(t *testing.T) {
+	one := token.Position{Line: 1, Column: 1}
+	two := token.Position{Line: 2, Column: 5}
+	three := token.Position{Line: 3, Column: 1}
+
+	var lt LineTable
+	lt = lt.Add(0, one)
+	lt = lt.Add(3, one)
+	lt = lt.Add(4, two)
+	lt = lt.Add(7, three)
+
+	if len(lt) != 3 {
+		t.Fatalf("wrong number of entries. want=3, got=%d", len(lt))
+	}
+
+	tests := []struct {
+		offset   int
+		expected token.Position
+	}{
+		{-1, token.Position{}},
+		{0, one},
+		{3, one},
+		{4, two},
+		{6, two},
+		{7, three},
+		{100, three},
+	}
+
+	for _, tt := range tests {
+		if pos := lt.PosFor(tt.offset); pos != tt.expected {
+			t.Errorf("wrong position for offset %d. want=%s, got=%s", tt.offset, tt.expected, pos)
+		}
+	}
+
+	lt = lt.Truncate(4)
+	if pos := lt.PosFor(7); pos != one {
+		t.Errorf("wrong position after truncate. want=%s, got=%s", one, pos)
+	}
+}
@@ -0,0 +1,26 @@
+package code
+
+// Handler describes a range of instructions protected by a try statement.
+// An error raised by an instruction in [Start, End) continues at Target,
+// with the stack cut back to Depth values above the locals of the frame and
+// the caught value pushed on top of them.
+type Handler struct {
+	Start  int
+	End    int
+	Target int
+	Depth  int
+}
+
+// HandlerTable lists the handlers of a function. Handlers of nested try
+// statements come before the handlers of the statements enclosing them.
+type HandlerTable []Handler
+
+// Find returns the innermost handler protecting the instruction at offset.
+func (ht HandlerTable) Find(offset int) (Handler, bool) {
+	for _, h := range ht {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
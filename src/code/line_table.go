@@ -0,0 +1,49 @@
+package code
+
+import (
+	"sort"
+
+	"monkey/token"
+)
+
+// LineEntry records that the instructions starting at Offset were
+// generated from the source at Pos.
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps instruction offsets back to source positions.
+// Entries are sorted by Offset; an entry covers every instruction up to the next entry.
+type LineTable []LineEntry
+
+// Add records pos for the instruction at offset. Consecutive instructions
+// from the same position share one entry.
+func (lt LineTable) Add(offset int, pos token.Position) LineTable {
+	if n := len(lt); n > 0 {
+		if lt[n-1].Pos == pos {
+			return lt
+		}
+		if lt[n-1].Offset == offset {
+			lt[n-1].Pos = pos
+			return lt
+		}
+	}
+	return append(lt, LineEntry{Offset: offset, Pos: pos})
+}
+
+// Truncate drops the entries for instructions at or after offset.
+func (lt LineTable) Truncate(offset int) LineTable {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset >= offset })
+	return lt[:i]
+}
+
+// PosFor returns the source position of the instruction at offset,
+// or an invalid position if it is unknown.
+func (lt LineTable) PosFor(offset int) token.Position {
+	i := sort.Search(len(lt), func(i int) bool { return lt[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return lt[i-1].Pos
+}
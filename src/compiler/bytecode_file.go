@@ -0,0 +1,348 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Layout of a .mkc file, all numbers are big-endian like the operands in
+// code.Instructions:
+//
+//	header    magic "MKC\x00", uint16 version, uint16 flags
+//	main      uint32 length, instructions, handler table
+//	constants uint32 count, then per constant a one byte tag and its payload:
+//	            integer  int64
+//	            float    IEEE 754 bits as uint64
+//	            string   uint32 length, bytes
+//	            function uint16 NumLocals, uint16 NumParameters, uint32 length, instructions,
+//	                     handler table
+//	debug     only when flagDebug is set:
+//	            uint32 count, file names as strings
+//	            line table of the main program
+//	            per function constant: name as a string, line table
+//
+// A line table is a uint32 count followed by its entries, each one being
+// uint32 instruction offset, uint32 file name index, uint32 byte offset,
+// uint32 line and uint32 column.
+//
+// A handler table is a uint32 count followed by its handlers, each one being
+// uint32 Start, uint32 End, uint32 Target and uint32 Depth.
+const (
+	BytecodeMagic   = "MKC\x00"
+	BytecodeVersion = 2
+
+	flagDebug = 1 << 0
+)
+
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagFunction
+)
+
+// WriteBytecode encodes bc to w. The function names and line tables used
+// for runtime error tracebacks are only written when debug is true.
+func WriteBytecode(w io.Writer, bc *Bytecode, debug bool) error {
+	e := &encoder{}
+
+	e.buf.WriteString(BytecodeMagic)
+	e.uint16(BytecodeVersion)
+	flags := 0
+	if debug {
+		flags |= flagDebug
+	}
+	e.uint16(flags)
+
+	e.bytes(bc.Instructions)
+	e.handlerTable(bc.Handlers)
+
+	e.uint32(len(bc.Constants))
+	for _, c := range bc.Constants {
+		switch c := c.(type) {
+		case *object.Integer:
+			e.buf.WriteByte(tagInteger)
+			e.uint64(uint64(c.Value))
+		case *object.Float:
+			e.buf.WriteByte(tagFloat)
+			e.uint64(math.Float64bits(c.Value))
+		case *object.String:
+			e.buf.WriteByte(tagString)
+			e.bytes([]byte(c.Value))
+		case *object.CompiledFunction:
+			e.buf.WriteByte(tagFunction)
+			e.uint16(c.NumLocals)
+			e.uint16(c.NumParameters)
+			e.bytes(c.Instructions)
+			e.handlerTable(c.Handlers)
+		default:
+			return fmt.Errorf("cannot encode constant of type %s", c.Type())
+		}
+	}
+
+	if debug {
+		e.debugSection(bc)
+	}
+
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+type encoder struct {
+	buf   bytes.Buffer
+	files map[string]int
+}
+
+func (e *encoder) uint16(v int) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uint32(v int) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint32(len(b))
+	e.buf.Write(b)
+}
+
+func (e *encoder) handlerTable(ht code.HandlerTable) {
+	e.uint32(len(ht))
+	for _, h := range ht {
+		e.uint32(h.Start)
+		e.uint32(h.End)
+		e.uint32(h.Target)
+		e.uint32(h.Depth)
+	}
+}
+
+func (e *encoder) debugSection(bc *Bytecode) {
+	// collect the file names first so that line entries can refer to them by index
+	var files []string
+	e.files = map[string]int{}
+	addFiles := func(lt code.LineTable) {
+		for _, entry := range lt {
+			if _, ok := e.files[entry.Pos.Filename]; !ok {
+				e.files[entry.Pos.Filename] = len(files)
+				files = append(files, entry.Pos.Filename)
+			}
+		}
+	}
+	addFiles(bc.LineTable)
+	for _, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			addFiles(fn.LineTable)
+		}
+	}
+
+	e.uint32(len(files))
+	for _, f := range files {
+		e.bytes([]byte(f))
+	}
+
+	e.lineTable(bc.LineTable)
+	for _, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			e.bytes([]byte(fn.Name))
+			e.lineTable(fn.LineTable)
+		}
+	}
+}
+
+func (e *encoder) lineTable(lt code.LineTable) {
+	e.uint32(len(lt))
+	for _, entry := range lt {
+		e.uint32(entry.Offset)
+		e.uint32(e.files[entry.Pos.Filename])
+		e.uint32(entry.Pos.Offset)
+		e.uint32(entry.Pos.Line)
+		e.uint32(entry.Pos.Column)
+	}
+}
+
+// ReadBytecode decodes bytecode written by WriteBytecode.
+func ReadBytecode(r io.Reader) (*Bytecode, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{data: data}
+
+	if string(d.next(len(BytecodeMagic))) != BytecodeMagic {
+		return nil, fmt.Errorf("not a monkey bytecode file")
+	}
+	version := d.uint16()
+	if d.err == nil && version != BytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+	flags := d.uint16()
+
+	bc := &Bytecode{Instructions: d.bytes(), Handlers: d.handlerTable()}
+
+	count := d.uint32()
+	for i := 0; i < count && d.err == nil; i++ {
+		var c object.Object
+		switch tag := d.byte(); tag {
+		case tagInteger:
+			c = &object.Integer{Value: int64(d.uint64())}
+		case tagFloat:
+			c = &object.Float{Value: math.Float64frombits(d.uint64())}
+		case tagString:
+			c = &object.String{Value: string(d.bytes())}
+		case tagFunction:
+			c = &object.CompiledFunction{
+				NumLocals:     d.uint16(),
+				NumParameters: d.uint16(),
+				Instructions:  d.bytes(),
+				Handlers:      d.handlerTable(),
+			}
+		default:
+			d.fail("unknown constant tag %d", tag)
+		}
+		bc.Constants = append(bc.Constants, c)
+	}
+
+	if flags&flagDebug != 0 {
+		d.debugSection(bc)
+	}
+
+	if d.err == nil && d.pos != len(d.data) {
+		d.fail("%d trailing bytes", len(d.data)-d.pos)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return bc, nil
+}
+
+// decoder reads from data, the first error sticks and makes every
+// following read return zero values.
+type decoder struct {
+	data  []byte
+	pos   int
+	err   error
+	files []string
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed bytecode at byte %d: %s", d.pos, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.pos {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) byte() byte {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() int {
+	b := d.next(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) uint32() int {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// bytes reads a length-prefixed blob, the result is a copy.
+func (d *decoder) bytes() []byte {
+	b := d.next(d.uint32())
+	return append([]byte{}, b...)
+}
+
+func (d *decoder) handlerTable() code.HandlerTable {
+	count := d.uint32()
+	var ht code.HandlerTable
+	for i := 0; i < count && d.err == nil; i++ {
+		ht = append(ht, code.Handler{
+			Start:  d.uint32(),
+			End:    d.uint32(),
+			Target: d.uint32(),
+			Depth:  d.uint32(),
+		})
+	}
+	return ht
+}
+
+func (d *decoder) debugSection(bc *Bytecode) {
+	count := d.uint32()
+	for i := 0; i < count && d.err == nil; i++ {
+		d.files = append(d.files, string(d.bytes()))
+	}
+
+	bc.LineTable = d.lineTable()
+	for _, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fn.Name = string(d.bytes())
+			fn.LineTable = d.lineTable()
+		}
+	}
+}
+
+func (d *decoder) lineTable() code.LineTable {
+	count := d.uint32()
+	var lt code.LineTable
+	for i := 0; i < count && d.err == nil; i++ {
+		offset := d.uint32()
+		file := d.uint32()
+		if d.err == nil && file >= len(d.files) {
+			d.fail("file index %d out of range", file)
+			return nil
+		}
+		pos := token.Position{Offset: d.uint32(), Line: d.uint32(), Column: d.uint32()}
+		if d.err == nil {
+			pos.Filename = d.files[file]
+		}
+		lt = append(lt, code.LineEntry{Offset: offset, Pos: pos})
+	}
+	return lt
+}
@@ -0,0 +1,144 @@
+package compiler
+
+import (
+	"bytes"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func compileForFile(t *testing.T, input string) *Bytecode {
+	t.Helper()
+
+	l := lexer.NewWithFilename("test.mk", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return compiler.Bytecode()
+}
+
+const bytecodeFileInput = `
+let add = fn(a, b) { a + b };
+let s = "monkey";
+add(1, 2.5);
+fn() { s }
+try { add(s, 1) } catch (e) { fn() { try { e } finally { s } } }
+`
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	bc := compileForFile(t, bytecodeFileInput)
+
+	var buf bytes.Buffer
+	err := WriteBytecode(&buf, bc, true)
+	if err != nil {
+		t.Fatalf("WriteBytecode failed: %s", err)
+	}
+
+	got, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode failed: %s", err)
+	}
+
+	if got.Instructions.String() != bc.Instructions.String() {
+		t.Errorf("wrong instructions.\nwant=%q\ngot =%q", bc.Instructions, got.Instructions)
+	}
+	if !reflect.DeepEqual(got.LineTable, bc.LineTable) {
+		t.Errorf("wrong line table.\nwant=%v\ngot =%v", bc.LineTable, got.LineTable)
+	}
+	if len(bc.Handlers) == 0 || !reflect.DeepEqual(got.Handlers, bc.Handlers) {
+		t.Errorf("wrong handlers.\nwant=%v\ngot =%v", bc.Handlers, got.Handlers)
+	}
+
+	if len(got.Constants) != len(bc.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(bc.Constants), len(got.Constants))
+	}
+	for i, want := range bc.Constants {
+		switch want := want.(type) {
+		case *object.CompiledFunction:
+			fn, ok := got.Constants[i].(*object.CompiledFunction)
+			if !ok {
+				t.Fatalf("constant %d is not CompiledFunction. got=%T", i, got.Constants[i])
+			}
+			if fn.Instructions.String() != want.Instructions.String() ||
+				fn.NumLocals != want.NumLocals || fn.NumParameters != want.NumParameters ||
+				fn.Name != want.Name || !reflect.DeepEqual(fn.LineTable, want.LineTable) ||
+				!reflect.DeepEqual(fn.Handlers, want.Handlers) {
+				t.Errorf("constant %d - wrong function.\nwant=%+v\ngot =%+v", i, want, fn)
+			}
+		default:
+			if got.Constants[i].Type() != want.Type() || got.Constants[i].Inspect() != want.Inspect() {
+				t.Errorf("constant %d - want=%s, got=%s", i, want.Inspect(), got.Constants[i].Inspect())
+			}
+		}
+	}
+}
+
+func TestBytecodeWithoutDebugInfo(t *testing.T) {
+	bc := compileForFile(t, bytecodeFileInput)
+
+	var withDebug, withoutDebug bytes.Buffer
+	if err := WriteBytecode(&withDebug, bc, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBytecode(&withoutDebug, bc, false); err != nil {
+		t.Fatal(err)
+	}
+	if withoutDebug.Len() >= withDebug.Len() {
+		t.Errorf("stripped bytecode is not smaller: %d >= %d", withoutDebug.Len(), withDebug.Len())
+	}
+
+	got, err := ReadBytecode(&withoutDebug)
+	if err != nil {
+		t.Fatalf("ReadBytecode failed: %s", err)
+	}
+	if len(got.LineTable) != 0 {
+		t.Errorf("line table was not stripped: %v", got.LineTable)
+	}
+	for _, c := range got.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok && fn.Name != "" {
+			t.Errorf("function name was not stripped: %q", fn.Name)
+		}
+	}
+}
+
+func TestReadMalformedBytecode(t *testing.T) {
+	bc := compileForFile(t, bytecodeFileInput)
+	var buf bytes.Buffer
+	if err := WriteBytecode(&buf, bc, true); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", nil, "not a monkey bytecode file"},
+		{"bad magic", []byte("MKX\x00\x00\x01\x00\x00"), "not a monkey bytecode file"},
+		{"bad version", []byte("MKC\x00\x00\x09\x00\x00"), "unsupported bytecode version 9, want 2"},
+		{"truncated", valid[:len(valid)-3], "unexpected end of data"},
+		{"trailing bytes", append(append([]byte{}, valid...), 0), "1 trailing bytes"},
+		{"huge length", []byte("MKC\x00\x00\x02\x00\x00\xff\xff\xff\xff"), "unexpected end of data"},
+		{"unknown tag", []byte("MKC\x00\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x09"), "unknown constant tag 9"},
+	}
+
+	for _, tt := range tests {
+		_, err := ReadBytecode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
@@ -5,13 +5,42 @@ import (
 	"monkey/ast"
 	"monkey/code"
 	"monkey/object"
-	"sort"
+	"monkey/token"
 )
 
+// maxLocals is the number of locals a function may have, bounded by the
+// 2-byte operand of a wide OpGetLocal and by NumLocals in .mkc files.
+const maxLocals = 1<<16 - 1
+
 type CompilationScope struct {
 	instructions        code.Instructions
+	lineTable           code.LineTable // source position of each emitted instruction
 	lastInstruction     EmmittedInstruction
 	previousInstruction EmmittedInstruction
+
+	loops []*loopContext // enclosing loops, innermost last
+	tries []*tryContext  // enclosing protected blocks, innermost last
+
+	handlers      code.HandlerTable
+	depth         int // number of values on the stack after the last instruction
+	pendingErrors int // number of finally handlers being compiled
+
+	bindings map[string]int // how often each name is bound or assigned in the function, nil at the top level
+}
+
+// loopContext tracks the jump targets of a loop being compiled.
+type loopContext struct {
+	continuePos int   // where `continue` jumps to
+	breakJumps  []int // positions of the `break` jumps, patched once the loop end is known
+}
+
+// tryContext tracks a try block, or the catch block of a try statement with
+// a finally block, while it is compiled.
+type tryContext struct {
+	finally *ast.BlockStatement // run whenever control leaves the block, may be nil
+	loops   int                 // number of loops around the try statement
+	start   int                 // start of the protected range, -1 while unprotected
+	ranges  [][2]int            // protected ranges, split by the copies of finally blocks
 }
 
 type EmmittedInstruction struct {
@@ -28,6 +57,12 @@ type Compiler struct {
 	constants []object.Object
 
 	symbolTable *SymbolTable
+
+	// position of the node being compiled, recorded for every emitted instruction
+	pos token.Position
+
+	// first instruction that could not be encoded, returned by Compile
+	err error
 }
 
 func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
@@ -67,6 +102,8 @@ func (c *Compiler) loadSymbol(s Symbol) {
 		c.emit(code.OpGetBuiltin, s.Index)
 	case FreeScope:
 		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
 	}
 }
 
@@ -96,9 +133,19 @@ func (c *Compiler) currentInstructions() code.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
 
+func (c *Compiler) currentLineTable() code.LineTable {
+	return c.scopes[c.scopeIndex].lineTable
+}
+
 // Compile walk through the ast and evaluate(just like the interpreter we made before),
 // then add constant pool, add opcode and operands to instructions.
 func (c *Compiler) Compile(node ast.Node) error {
+	if pos := node.Pos(); pos.IsValid() {
+		outerPos := c.pos
+		c.pos = pos
+		defer func() { c.pos = outerPos }()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -127,11 +174,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "-":
 			c.emit(code.OpMinus)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return newError(node, "unknown operator %s", node.Operator)
 		}
 	case *ast.InfixExpression:
+		switch node.Operator {
+		case "&&", "||":
+			return c.compileLogical(node)
+		}
+
 		// reorder "left < right" to "right > left"
-		if node.Operator == "<" {
+		if node.Operator == "<" || node.Operator == "<=" {
 			err := c.Compile(node.Right)
 			if err != nil {
 				return err
@@ -142,7 +194,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			c.emit(code.OpGreaterThan)
+			if node.Operator == "<" {
+				c.emit(code.OpGreaterThan)
+			} else {
+				c.emit(code.OpGreaterThanOrEqual)
+			}
 			return nil
 		}
 
@@ -165,18 +221,27 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMul)
 		case "/":
 			c.emit(code.OpDiv)
+		case "%":
+			c.emit(code.OpMod)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterThanOrEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
+		case "in":
+			c.emit(code.OpIn)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return newError(node, "unknown operator %s", node.Operator)
 		}
 	case *ast.IntegerLiteral:
 		intObj := &object.Integer{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(intObj))
+	case *ast.FloatLiteral:
+		floatObj := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(floatObj))
 	case *ast.StringLiteral:
 		strObj := &object.String{Value: node.Value}
 		c.emit(code.OpConstant, c.addConstant(strObj))
@@ -199,21 +264,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		depth := c.scopes[c.scopeIndex].depth
 
 		err = c.Compile(node.Consequence)
 		if err != nil {
 			return err
 		}
 
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
-		}
+		c.keepBlockValue()
 
 		// emit an `OpJump` with a bogus value
 		// compile Alternative 完成后，会changeOperand做替换
 		jumpPos := c.emit(code.OpJump, 9999)
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		c.scopes[c.scopeIndex].depth = depth
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
@@ -223,9 +288,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
-			}
+			c.keepBlockValue()
 		}
 
 		afterAltPos := len(c.currentInstructions())
@@ -239,21 +302,85 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 	case *ast.LetStatement:
-		symbol := c.symbolTable.Define(node.Name.Value)
+		symbol := c.symbolTable.Redefine(node.Name.Value)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
 
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
+		c.storeSymbol(symbol)
+	case *ast.WhileStatement:
+		loopStart := len(c.currentInstructions())
+
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
 		}
+
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.enterLoop(loopStart)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthyPos, afterLoopPos)
+		c.leaveLoop(afterLoopPos)
+	case *ast.ForInStatement:
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+
+		// the iterator lives in a hidden variable, so that the stack is
+		// balanced around the body and break/continue are plain jumps.
+		// "$iter" is never resolved by name, nested loops get their own slot.
+		iterator := c.symbolTable.Define("$iter")
+		c.emit(code.OpIterInit)
+		c.storeSymbol(iterator)
+
+		loopStart := len(c.currentInstructions())
+		c.loadSymbol(iterator)
+		iterNextPos := c.emit(code.OpIterNext, 9999)
+		c.storeSymbol(c.symbolTable.Redefine(node.Variable.Value))
+
+		c.enterLoop(loopStart)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loopStart)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(iterNextPos, afterLoopPos)
+		c.leaveLoop(afterLoopPos)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return newError(node, "break outside loop")
+		}
+		err := c.leaveTries(c.triesInLoop())
+		if err != nil {
+			return err
+		}
+		loop.breakJumps = append(loop.breakJumps, c.emit(code.OpJump, 9999))
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return newError(node, "continue outside loop")
+		}
+		err := c.leaveTries(c.triesInLoop())
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, loop.continuePos)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return newError(node, "undefined variable %s", node.Value)
 		}
 		c.loadSymbol(symbol)
 
@@ -266,16 +393,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpArray, len(node.Elements))
 	case *ast.HashLiteral:
-
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		for _, k := range node.Keys {
 			err := c.Compile(k)
 			if err != nil {
 				return err
@@ -288,6 +406,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 		c.emit(code.OpHash, len(node.Pairs)*2)
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
 	case *ast.IndexExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -301,8 +421,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpIndex)
 	case *ast.FunctionLiteral:
+		// A function bound by a local let refers to itself with
+		// OpCurrentClosure, unless the name is bound again or assigned, so
+		// that it may name another function by the time this one runs. A
+		// global is read when it is used anyway.
+		self := false
+		if node.Name != "" {
+			symbol, ok := c.symbolTable.Resolve(node.Name)
+			self = ok && symbol.Scope == LocalScope && c.scopes[c.scopeIndex].bindings[node.Name] == 1
+		}
+
 		// 对于函数定义，开一个scope（stack frame）存储编译好的函数体指令
 		c.enterScope()
+		c.scopes[c.scopeIndex].bindings = map[string]int{}
+		countBindings(node.Body, c.scopes[c.scopeIndex].bindings)
+
+		if self {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
 
 		for _, p := range node.Parameters {
 			c.symbolTable.Define(p.Value)
@@ -323,17 +459,25 @@ func (c *Compiler) Compile(node ast.Node) error {
 		freeSymbols := c.symbolTable.FreeSymbols
 		// before leaving scope, count number of locals
 		numLocals := c.symbolTable.numDefinitions
+		lineTable := c.currentLineTable()
+		handlers := c.scopes[c.scopeIndex].handlers
+		if numLocals > maxLocals {
+			return newError(node, "too many local variables: %d, the maximum is %d", numLocals, maxLocals)
+		}
 
 		// 把编译好的函数体指令，放在常量池中，以便后续调用。
 		instructions := c.leaveScope()
 		for _, s := range freeSymbols {
-			c.loadSymbol(s)
+			c.captureSymbol(s)
 		}
 
 		compiledFn := &object.CompiledFunction{
+			Name:          node.Name,
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			LineTable:     lineTable,
+			Handlers:      handlers,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
@@ -341,10 +485,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
-			return nil
+			return err
+		}
+
+		err = c.leaveTries(len(c.scopes[c.scopeIndex].tries))
+		if err != nil {
+			return err
 		}
 
 		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpThrow)
+	case *ast.TryStatement:
+		return c.compileTry(node)
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
 		if err != nil {
@@ -360,6 +518,455 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 		c.emit(code.OpCall, len(node.Arguments))
 	}
+	return c.err
+}
+
+// keepBlockValue leaves the value of a compiled if/else block on the stack:
+// the OpPop of a trailing expression statement is removed, and a block that
+// does not end with an expression produces null.
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// captureSymbol pushes the cell of a variable captured by a closure.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	default:
+		c.loadSymbol(s)
+	}
+}
+
+// compileAssign compiles `target = value` and `target op= value`, leaving
+// the assigned value on the stack.
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	compound := node.Operator != "="
+	op, ok := compoundOperators[node.Operator]
+	if compound && !ok {
+		return newError(node, "unknown operator %s", node.Operator)
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return newError(target, "undefined variable %s", target.Value)
+		}
+		if symbol.Scope != GlobalScope && symbol.Scope != LocalScope && symbol.Scope != FreeScope {
+			return newError(target, "cannot assign to %s", target.Value)
+		}
+
+		if compound {
+			c.loadSymbol(symbol)
+		}
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+
+		c.storeSymbol(symbol)
+		c.loadSymbol(symbol)
+	case *ast.IndexExpression:
+		if !compound {
+			err := c.Compile(target.Left)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(target.Index)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Value)
+			if err != nil {
+				return err
+			}
+			c.emit(code.OpSetIndex)
+			return nil
+		}
+
+		// evaluate the container and the index only once. The hidden
+		// variables are shared by all compound assignments of the scope, so
+		// they are only stored once both operands are evaluated, in case
+		// the operands contain compound assignments themselves.
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		index := c.symbolTable.Redefine("$index")
+		c.storeSymbol(index)
+		container := c.symbolTable.Redefine("$container")
+		c.storeSymbol(container)
+
+		c.loadSymbol(container)
+		c.loadSymbol(index)
+		c.loadSymbol(container)
+		c.loadSymbol(index)
+		c.emit(code.OpIndex)
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(op)
+		c.emit(code.OpSetIndex)
+	default:
+		return newError(node, "cannot assign to %s", node.Target)
+	}
+
+	return nil
+}
+
+// countBindings counts how often each name is bound by a let, a for loop or
+// a catch clause, or assigned, anywhere in node including nested functions.
+func countBindings(node ast.Node, bindings map[string]int) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			countBindings(s, bindings)
+		}
+	case *ast.LetStatement:
+		bindings[node.Name.Value]++
+		countBindings(node.Value, bindings)
+	case *ast.ReturnStatement:
+		countBindings(node.ReturnValue, bindings)
+	case *ast.ExpressionStatement:
+		countBindings(node.Expression, bindings)
+	case *ast.WhileStatement:
+		countBindings(node.Condition, bindings)
+		countBindings(node.Body, bindings)
+	case *ast.ForInStatement:
+		bindings[node.Variable.Value]++
+		countBindings(node.Iterable, bindings)
+		countBindings(node.Body, bindings)
+	case *ast.ThrowStatement:
+		countBindings(node.Value, bindings)
+	case *ast.TryStatement:
+		countBindings(node.Block, bindings)
+		if node.Catch != nil {
+			bindings[node.CatchParam.Value]++
+			countBindings(node.Catch, bindings)
+		}
+		if node.Finally != nil {
+			countBindings(node.Finally, bindings)
+		}
+	case *ast.PrefixExpression:
+		countBindings(node.Right, bindings)
+	case *ast.InfixExpression:
+		countBindings(node.Left, bindings)
+		countBindings(node.Right, bindings)
+	case *ast.IfExpression:
+		countBindings(node.Condition, bindings)
+		countBindings(node.Consequence, bindings)
+		if node.Alternative != nil {
+			countBindings(node.Alternative, bindings)
+		}
+	case *ast.FunctionLiteral:
+		countBindings(node.Body, bindings)
+	case *ast.CallExpression:
+		countBindings(node.Function, bindings)
+		for _, arg := range node.Arguments {
+			countBindings(arg, bindings)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			countBindings(el, bindings)
+		}
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			countBindings(key, bindings)
+			countBindings(node.Pairs[key], bindings)
+		}
+	case *ast.IndexExpression:
+		countBindings(node.Left, bindings)
+		countBindings(node.Index, bindings)
+	case *ast.AssignExpression:
+		if target, ok := node.Target.(*ast.Identifier); ok {
+			bindings[target.Value]++
+		} else {
+			countBindings(node.Target, bindings)
+		}
+		countBindings(node.Value, bindings)
+	}
+}
+
+// compileLogical lowers "&&" and "||" to jumps so that the right operand is
+// only evaluated when needed. The result is always a boolean:
+//
+//	a && b:  a; OpJumpNotTruthy F; b; OpBang; OpBang; OpJump END; F: OpFalse; END:
+//	a || b:  a; OpJumpNotTruthy R; OpTrue; OpJump END; R: b; OpBang; OpBang; END:
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+	depth := c.scopes[c.scopeIndex].depth
+
+	if node.Operator == "||" {
+		c.emit(code.OpTrue)
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.scopes[c.scopeIndex].depth = depth
+
+		err := c.compileTruthiness(node.Right)
+		if err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	err = c.compileTruthiness(node.Right)
+	if err != nil {
+		return err
+	}
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].depth = depth
+	c.emit(code.OpFalse)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// compileTruthiness compiles node and converts its value to a boolean.
+func (c *Compiler) compileTruthiness(node ast.Expression) error {
+	err := c.Compile(node)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
+// compoundOperators maps compound assignment operators to the opcode
+// combining the current and the new value.
+var compoundOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+func (c *Compiler) enterLoop(continuePos int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &loopContext{continuePos: continuePos})
+}
+
+// leaveLoop patches the break jumps of the innermost loop to afterLoopPos.
+func (c *Compiler) leaveLoop(afterLoopPos int) {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range loop.breakJumps {
+		c.changeOperand(pos, afterLoopPos)
+	}
+}
+
+func (c *Compiler) currentLoop() *loopContext {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+// compileTry compiles a try statement. The try and catch blocks are
+// protected by handlers, and a copy of the finally block is run on every way
+// out of them:
+//
+//	block; finally; OpJump END
+//	C: (handler of block) set e; catch; finally; OpJump END
+//	F: (handler of block and catch) set $error; finally; get $error; OpThrow
+//	END:
+//
+// return, break and continue run the copies of the finally blocks they
+// leave before jumping, see leaveTries.
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	depth := c.scopes[c.scopeIndex].depth
+	var endJumps []int
+
+	try := c.enterTry(node.Finally)
+	err := c.Compile(node.Block)
+	if err != nil {
+		return err
+	}
+	c.leaveTry()
+
+	err = c.compileFinally(node.Finally)
+	if err != nil {
+		return err
+	}
+	endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	protected := try.ranges
+
+	if node.Catch != nil {
+		c.addHandlers(protected, depth)
+		protected = nil
+
+		// the caught value is pushed on entry to a handler
+		c.scopes[c.scopeIndex].depth = depth + 1
+		if node.Finally != nil {
+			try = c.enterTry(node.Finally)
+		}
+		c.storeSymbol(c.symbolTable.Redefine(node.CatchParam.Value))
+		err := c.Compile(node.Catch)
+		if err != nil {
+			return err
+		}
+		if node.Finally != nil {
+			c.leaveTry()
+			protected = try.ranges
+		}
+
+		err = c.compileFinally(node.Finally)
+		if err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	}
+
+	if node.Finally != nil {
+		c.addHandlers(protected, depth)
+
+		// the error is kept in a hidden variable while the finally block
+		// runs. Try statements in that finally block need their own, so the
+		// variable is shared by the finally handlers at the same nesting.
+		scope := &c.scopes[c.scopeIndex]
+		scope.depth = depth + 1
+		pending := c.symbolTable.Redefine(fmt.Sprintf("$error%d", scope.pendingErrors))
+		c.storeSymbol(pending)
+		scope.pendingErrors++
+		err := c.compileFinally(node.Finally)
+		c.scopes[c.scopeIndex].pendingErrors--
+		if err != nil {
+			return err
+		}
+		c.loadSymbol(pending)
+		c.emit(code.OpThrow)
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	c.scopes[c.scopeIndex].depth = depth
+
+	return nil
+}
+
+func (c *Compiler) compileFinally(finally *ast.BlockStatement) error {
+	if finally == nil {
+		return nil
+	}
+	return c.Compile(finally)
+}
+
+// addHandlers makes the protected ranges continue at the next instruction.
+func (c *Compiler) addHandlers(ranges [][2]int, depth int) {
+	scope := &c.scopes[c.scopeIndex]
+	for _, r := range ranges {
+		scope.handlers = append(scope.handlers, code.Handler{
+			Start:  r[0],
+			End:    r[1],
+			Target: len(scope.instructions),
+			Depth:  depth,
+		})
+	}
+}
+
+// enterTry starts a block protected until the matching leaveTry.
+func (c *Compiler) enterTry(finally *ast.BlockStatement) *tryContext {
+	scope := &c.scopes[c.scopeIndex]
+	try := &tryContext{
+		finally: finally,
+		loops:   len(scope.loops),
+		start:   len(scope.instructions),
+	}
+	scope.tries = append(scope.tries, try)
+	return try
+}
+
+func (c *Compiler) leaveTry() {
+	scope := &c.scopes[c.scopeIndex]
+	try := scope.tries[len(scope.tries)-1]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	c.unprotect(try)
+}
+
+func (c *Compiler) unprotect(try *tryContext) {
+	end := len(c.currentInstructions())
+	if try.start >= 0 && try.start < end {
+		try.ranges = append(try.ranges, [2]int{try.start, end})
+	}
+	try.start = -1
+}
+
+// triesInLoop returns the number of innermost protected blocks inside the
+// innermost loop, the ones left by break and continue.
+func (c *Compiler) triesInLoop() int {
+	scope := c.scopes[c.scopeIndex]
+	n := 0
+	for i := len(scope.tries) - 1; i >= 0 && scope.tries[i].loops >= len(scope.loops); i-- {
+		n++
+	}
+	return n
+}
+
+// leaveTries emits the finally blocks of the n innermost protected blocks,
+// innermost first, for a jump out of them. Each copy is protected by the
+// blocks around it only, and a jump inside it leaves those blocks.
+func (c *Compiler) leaveTries(n int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	loops := c.scopes[c.scopeIndex].loops
+	exited := tries[len(tries)-n:]
+
+	for i := len(tries) - 1; i >= len(tries)-n; i-- {
+		try := tries[i]
+		c.unprotect(try)
+		if try.finally == nil {
+			continue
+		}
+
+		c.scopes[c.scopeIndex].tries = tries[:i:i]
+		c.scopes[c.scopeIndex].loops = loops[:try.loops:try.loops]
+		err := c.Compile(try.finally)
+		c.scopes[c.scopeIndex].tries = tries
+		c.scopes[c.scopeIndex].loops = loops
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, try := range exited {
+		try.start = len(c.currentInstructions())
+	}
 	return nil
 }
 
@@ -379,7 +986,11 @@ func (c *Compiler) replaceInstruction(pos int, newInstructions []byte) {
 
 func (c *Compiler) changeOperand(opPos int, operand int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+	newInstruction, err := code.Encode(op, operand)
+	if err != nil {
+		c.fail(err)
+		return
+	}
 
 	c.replaceInstruction(opPos, newInstruction)
 }
@@ -400,18 +1011,37 @@ func (c *Compiler) removeLastPop() {
 	newIns := old[:last.Position]
 
 	c.scopes[c.scopeIndex].instructions = newIns
+	c.scopes[c.scopeIndex].lineTable = c.currentLineTable().Truncate(last.Position)
 	c.scopes[c.scopeIndex].lastInstruction = prev
+	c.scopes[c.scopeIndex].depth++
 }
 
+// emit appends op, in its wide form if an operand needs it. Operands too
+// large even for that fail the compilation.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	ins, err := code.Encode(op, operands...)
+	if err != nil {
+		c.fail(err)
+		ins = code.Make(op, operands...)
+	}
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
 
+	pops, pushes := code.StackEffect(op, operands)
+	c.scopes[c.scopeIndex].depth += pushes - pops
+
 	return pos
 }
 
+// fail records the first error that occurs while emitting instructions,
+// Compile returns it when it is done with the current node.
+func (c *Compiler) fail(err error) {
+	if c.err == nil {
+		c.err = fmt.Errorf("%s: %s", c.pos, err)
+	}
+}
+
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	previous := c.scopes[c.scopeIndex].lastInstruction
 	last := EmmittedInstruction{Opcode: op, Position: pos}
@@ -430,6 +1060,8 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		LineTable:    c.currentLineTable(),
+		Handlers:     c.scopes[c.scopeIndex].handlers,
 	}
 }
 
@@ -437,10 +1069,20 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	// append the new instruction(ins) to the last of instruction slice
 	posNewInstruction := len(c.currentInstructions())
 	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	if c.pos.IsValid() {
+		c.scopes[c.scopeIndex].lineTable = c.currentLineTable().Add(posNewInstruction, c.pos)
+	}
 	return posNewInstruction
 }
 
+// newError builds a compilation error prefixed with the position of node.
+func newError(node ast.Node, format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s", node.Pos(), fmt.Sprintf(format, a...))
+}
+
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	LineTable    code.LineTable    // source positions of the main program's Instructions
+	Handlers     code.HandlerTable // the try statements of the main program
 }
@@ -7,6 +7,8 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -109,15 +111,15 @@ func TestClosures(t *testing.T) {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -135,6 +137,424 @@ func TestClosures(t *testing.T) {
 
 }
 
+func TestAssignments(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `let x = 1; x = 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let x = 1; x += 2;`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let a = [1]; a[0] = 2;`,
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { let a = 1; fn() { a = 2 } }`,
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`x = 1;`, "1:1: undefined variable x"},
+		{`len = 1;`, "1:1: cannot assign to len"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `while (true) { 1; break; continue; }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 17),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 17),
+				// 0011
+				code.Make(code.OpJump, 0),
+				// 0014
+				code.Make(code.OpJump, 0),
+			},
+		},
+		{
+			input:             `for (x in [1]) { x }`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIterInit),
+				// 0007
+				code.Make(code.OpSetGlobal, 0),
+				// 0010
+				code.Make(code.OpGetGlobal, 0),
+				// 0013
+				code.Make(code.OpIterNext, 26),
+				// 0016
+				code.Make(code.OpSetGlobal, 1),
+				// 0019
+				code.Make(code.OpGetGlobal, 1),
+				// 0022
+				code.Make(code.OpPop),
+				// 0023
+				code.Make(code.OpJump, 10),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"while (true) { fn() { continue; } }", "1:23: continue outside loop"},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error but resulted in none.")
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		compilerTestCase
+		expectedHandlers code.HandlerTable
+	}{
+		{
+			compilerTestCase{
+				input:             `try { 1; } catch (e) { e; }`,
+				expectedConstants: []interface{}{1},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpPop),
+					// 0004
+					code.Make(code.OpJump, 17),
+					// 0007
+					code.Make(code.OpSetGlobal, 0),
+					// 0010
+					code.Make(code.OpGetGlobal, 0),
+					// 0013
+					code.Make(code.OpPop),
+					// 0014
+					code.Make(code.OpJump, 17),
+				},
+			},
+			code.HandlerTable{{Start: 0, End: 4, Target: 7, Depth: 0}},
+		},
+		{
+			compilerTestCase{
+				input:             `while (true) { try { break; } finally { 2; } }`,
+				expectedConstants: []interface{}{2, 2, 2},
+				expectedInstructions: []code.Instructions{
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 32),
+					// 0004, the finally block run by break
+					code.Make(code.OpConstant, 0),
+					// 0007
+					code.Make(code.OpPop),
+					// 0008
+					code.Make(code.OpJump, 32),
+					// 0011, the finally block at the end of the try block
+					code.Make(code.OpConstant, 1),
+					// 0014
+					code.Make(code.OpPop),
+					// 0015
+					code.Make(code.OpJump, 29),
+					// 0018, the finally block run for an error
+					code.Make(code.OpSetGlobal, 0),
+					// 0021
+					code.Make(code.OpConstant, 2),
+					// 0024
+					code.Make(code.OpPop),
+					// 0025
+					code.Make(code.OpGetGlobal, 0),
+					// 0028
+					code.Make(code.OpThrow),
+					// 0029
+					code.Make(code.OpJump, 0),
+				},
+			},
+			code.HandlerTable{{Start: 8, End: 11, Target: 18, Depth: 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		runCompilerTests(t, []compilerTestCase{tt.compilerTestCase})
+
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		handlers := compiler.Bytecode().Handlers
+		if !reflect.DeepEqual(handlers, tt.expectedHandlers) {
+			t.Errorf("wrong handlers for %q.\nwant=%+v\ngot =%+v", tt.input, tt.expectedHandlers, handlers)
+		}
+	}
+}
+
+func TestTryStatementHandlerDepth(t *testing.T) {
+	compiler := New()
+	err := compiler.Compile(parse(`[1, fn() { try { throw 2; } catch (e) { e } }()]`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn := compiler.Bytecode().Constants[2].(*object.CompiledFunction)
+	expected := code.HandlerTable{{Start: 0, End: 4, Target: 7, Depth: 0}}
+	if !reflect.DeepEqual(fn.Handlers, expected) {
+		t.Errorf("wrong handlers.\nwant=%+v\ngot =%+v", expected, fn.Handlers)
+	}
+
+	// a try statement inside an expression keeps the values pushed before it
+	compiler = New()
+	err = compiler.Compile(parse(`[1, if (true) { try { 2 } catch (e) { 3 }; 4 }]`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	expected = code.HandlerTable{{Start: 7, End: 11, Target: 14, Depth: 1}}
+	if handlers := compiler.Bytecode().Handlers; !reflect.DeepEqual(handlers, expected) {
+		t.Errorf("wrong handlers.\nwant=%+v\ngot =%+v", expected, handlers)
+	}
+}
+
+func TestCompoundIndexAssignmentVariables(t *testing.T) {
+	// the hidden container and index variables are shared by the scope
+	compiler := New()
+	err := compiler.Compile(parse(`
+	let a = [1, 2];
+	a[0] += 1;
+	a[1] *= 2;
+	let f = fn() { let b = [1]; b[0] += 1; b[0] -= 1; b };
+	`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	if n := compiler.symbolTable.numDefinitions; n != 4 {
+		t.Errorf("wrong number of globals. want=4, got=%d", n)
+	}
+	for _, constant := range compiler.Bytecode().Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok && fn.NumLocals != 3 {
+			t.Errorf("wrong number of locals. want=3, got=%d", fn.NumLocals)
+		}
+	}
+}
+
+func TestFinallyErrorVariables(t *testing.T) {
+	// a finally handler keeps the error in a hidden variable shared by the
+	// try statements at the same nesting
+	compiler := New()
+	err := compiler.Compile(parse(`
+	fn() {
+		try {} finally {}
+		try {} finally {}
+		try {} finally { try {} finally {} }
+	}
+	`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn := compiler.Bytecode().Constants[0].(*object.CompiledFunction)
+	if fn.NumLocals != 2 {
+		t.Errorf("wrong number of locals. want=2, got=%d", fn.NumLocals)
+	}
+}
+
+func TestRecursiveFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) { countDown(x - 1); };
+				countDown(1);
+			};
+			wrapper();
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCurrentClosure),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// a global is read when the function runs
+			input: `
+			let countDown = fn(x) { countDown(x - 1); };
+			countDown(1);
+			`,
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// an assigned local is captured like any other
+			input: `
+			let wrapper = fn() {
+				let f = fn() { f(); };
+				f = 1;
+			};
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestBuiltins(t *testing.T) {
 	tests := []compilerTestCase{
 		{
@@ -522,6 +942,16 @@ func TestExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             `"a" in "cat"`,
+			expectedConstants: []interface{}{"a", "cat"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIn),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -702,6 +1132,70 @@ func TestBooleanExpression(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThanOrEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpBang),
+				// 0006
+				code.Make(code.OpBang),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpFalse),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 11),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpBang),
+				// 0010
+				code.Make(code.OpBang),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "1 == 2",
 			expectedConstants: []interface{}{1, 2},
@@ -822,6 +1316,134 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestCompilerErrorPositions(t *testing.T) {
+	program := parse(`let a = 1;
+let f = fn() { a + b };`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err == nil {
+		t.Fatalf("expected compiler error but resulted in none.")
+	}
+
+	expected := "2:20: undefined variable b"
+	if err.Error() != expected {
+		t.Errorf("wrong compiler error. want=%q, got=%q", expected, err)
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// fn() { let va = 0; ... let vjw = 0; vjw }, 257 locals
+	var body strings.Builder
+	for i := 0; i < 257; i++ {
+		fmt.Fprintf(&body, "let %s = 0; ", identifier(i))
+	}
+	body.WriteString(identifier(256))
+
+	program := parse("fn() { " + body.String() + " }")
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	constants := compiler.Bytecode().Constants
+	fn := constants[len(constants)-1].(*object.CompiledFunction)
+	expected := concatInstructions([]code.Instructions{
+		code.MakeWide(code.OpGetLocal, 256),
+		code.Make(code.OpReturnValue),
+	})
+	tail := fn.Instructions[len(fn.Instructions)-len(expected):]
+	if err := testInstructions([]code.Instructions{expected}, tail); err != nil {
+		t.Errorf("wrong end of the function: %s", err)
+	}
+	if !strings.Contains(fn.Instructions.String(), "OpWide OpSetLocal 256") {
+		t.Errorf("OpSetLocal 256 is not wide:\n%s", fn.Instructions)
+	}
+}
+
+func TestOperandLimits(t *testing.T) {
+	// the jump over an if block longer than a 2-byte jump target can reach
+	var long strings.Builder
+	long.WriteString("if (true) { ")
+	for i := 0; i < 20000; i++ {
+		long.WriteString("1; ")
+	}
+	long.WriteString("}")
+
+	compiler := New()
+	err := compiler.Compile(parse(long.String()))
+	expected := "1:1: operand 80006 of OpJumpNotTruthy does not fit, the maximum is 65535"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong compiler error. want=%q, got=%v", expected, err)
+	}
+}
+
+// identifier returns the i-th of the names va, vb, ..., vz, vba, vbb, ...
+// since identifiers cannot contain digits.
+func identifier(i int) string {
+	name := string(rune('a' + i%26))
+	for i /= 26; i > 0; i /= 26 {
+		name = string(rune('a'+i%26)) + name
+	}
+	return "v" + name
+}
+
+func TestLineTables(t *testing.T) {
+	program := parse(`let a = 1;
+let f = fn(x) {
+  x +
+    a
+};`)
+
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler err:%s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+
+	// OpConstant 0, OpSetGlobal 0, OpClosure 1 0, OpSetGlobal 1
+	mainTests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "1:9"},
+		{3, "1:1"},
+		{6, "2:9"},
+		{10, "2:1"},
+	}
+	for _, tt := range mainTests {
+		pos := bytecode.LineTable.PosFor(tt.offset)
+		if pos.String() != tt.expected {
+			t.Errorf("main: wrong position at %d. want=%q, got=%q", tt.offset, tt.expected, pos)
+		}
+	}
+
+	fn, ok := bytecode.Constants[1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 1 - not a function: %T", bytecode.Constants[1])
+	}
+
+	// OpGetLocal 0, OpGetGlobal 0, OpAdd, OpReturnValue
+	fnTests := []struct {
+		offset   int
+		expected string
+	}{
+		{0, "3:3"},
+		{2, "4:5"},
+		{5, "3:5"},
+		{6, "3:3"},
+	}
+	for _, tt := range fnTests {
+		pos := fn.LineTable.PosFor(tt.offset)
+		if pos.String() != tt.expected {
+			t.Errorf("fn: wrong position at %d. want=%q, got=%q", tt.offset, tt.expected, pos)
+		}
+	}
+}
+
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	t.Helper()
 	for _, test := range tests {
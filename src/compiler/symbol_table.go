@@ -6,6 +6,7 @@ const GlobalScope SymbolScope = "GLOBAL"
 const LocalScope SymbolScope = "LOCAL"
 const BuiltinScope SymbolScope = "BUILTIN"
 const FreeScope SymbolScope = "FREE"
+const FunctionScope SymbolScope = "FUNCTION"
 
 type Symbol struct {
 	Name  string
@@ -33,6 +34,25 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
+// Copy returns a table with the same symbols whose definitions do not affect
+// s, so that a failed compilation can be thrown away. The outer tables are
+// shared.
+func (s *SymbolTable) Copy() *SymbolTable {
+	store := make(map[string]Symbol, len(s.store))
+	for name, symbol := range s.store {
+		store[name] = symbol
+	}
+	free := make([]Symbol, len(s.FreeSymbols))
+	copy(free, s.FreeSymbols)
+
+	return &SymbolTable{
+		Outer:          s.Outer,
+		FreeSymbols:    free,
+		store:          store,
+		numDefinitions: s.numDefinitions,
+	}
+}
+
 func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.FreeSymbols = append(s.FreeSymbols, original)
 
@@ -55,6 +75,16 @@ func (s *SymbolTable) Define(name string) Symbol {
 	return symbol
 }
 
+// Redefine is Define for `let`: a name already defined as a variable in this
+// very table keeps its slot, so rebinding updates the existing variable.
+func (s *SymbolTable) Redefine(name string) Symbol {
+	symbol, ok := s.store[name]
+	if ok && (symbol.Scope == GlobalScope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	return s.Define(name)
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
@@ -73,6 +103,14 @@ func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	return obj, ok
 }
 
+// DefineFunctionName defines the name of the function owning this table,
+// so that the function can refer to itself without capturing a free variable.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
 func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
 	s.store[name] = symbol
@@ -40,6 +40,39 @@ func TestResolveUnresolvableFree(t *testing.T) {
 	}
 }
 
+func TestDefineAndResolveFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+
+	expected := Symbol{Name: "a", Scope: FunctionScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
+func TestShadowingFunctionName(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineFunctionName("a")
+	global.Define("a")
+
+	expected := Symbol{Name: "a", Scope: GlobalScope, Index: 0}
+
+	result, ok := global.Resolve(expected.Name)
+	if !ok {
+		t.Fatalf("function name %s not resolvable", expected.Name)
+	}
+
+	if result != expected {
+		t.Errorf("expected %s to resolve to %+v, got=%+v", expected.Name, expected, result)
+	}
+}
+
 func TestResolveFree(t *testing.T) {
 	global := NewSymbolTable()
 	global.Define("a")
@@ -290,3 +323,23 @@ func TestResolveNestedLocal(t *testing.T) {
 		}
 	}
 }
+
+func TestCopy(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.Define("a")
+
+	copied := global.Copy()
+	b := copied.Define("b")
+	if b != (Symbol{Name: "b", Scope: GlobalScope, Index: 1}) {
+		t.Errorf("wrong symbol in the copy. got=%+v", b)
+	}
+	if result, ok := copied.Resolve("a"); !ok || result != a {
+		t.Errorf("a should be copied. got=%+v", result)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("b should not be defined in the original")
+	}
+	if c := global.Define("c"); c.Index != 1 {
+		t.Errorf("wrong index in the original. got=%d", c.Index)
+	}
+}
@@ -0,0 +1,537 @@
+package monkey
+
+import (
+	"fmt"
+	"math"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ToObject converts a Go value to a Monkey object:
+//
+//	nil, nil pointers       null
+//	bool                    BOOLEAN
+//	signed and unsigned int INTEGER
+//	float32, float64        FLOAT
+//	string                  STRING
+//	slices and arrays       ARRAY
+//	maps                    HASH with sorted keys, which must convert to hashable objects
+//	structs                 HASH with a STRING key per exported field
+//	funcs                   BUILTIN, see below
+//	object.Object           itself
+//
+// Struct fields are named by a `monkey:"name"` tag or else by the field
+// name, the tag "-" leaves a field out.
+//
+// A func becomes a builtin converting its arguments with FromObject and its
+// results with ToObject. A trailing error result stops the script when it
+// is not nil, the other results are returned as they are when there is
+// one, as an ARRAY when there are more and as null when there are none.
+func ToObject(v interface{}) (object.Object, error) {
+	if v == nil {
+		return vm.Null, nil
+	}
+	return toObject(reflect.ValueOf(v), map[visit]bool{})
+}
+
+// visit identifies a pointer, map or slice being converted. A slice is
+// identified by its length as well, as a shorter slice of the same array
+// is a different value.
+type visit struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// toObject converts v, seen holds the pointers, maps and slices being
+// converted further up, so that a value holding itself fails instead of
+// recursing forever.
+func toObject(v reflect.Value, seen map[visit]bool) (object.Object, error) {
+	// Nil interfaces and pointers become null, also a nil object.Object and
+	// a nil pointer held by an interface, which is unwrapped first.
+	if (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && v.IsNil() {
+		return vm.Null, nil
+	}
+	if v.Kind() == reflect.Interface {
+		return toObject(v.Elem(), seen)
+	}
+	if v.Type().Implements(objectType) {
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		key := visit{typ: v.Type(), ptr: v.Pointer()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("cannot convert %s containing itself", v.Type())
+		}
+		seen[key] = true
+		defer delete(seen, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return vm.True, nil
+		}
+		return vm.False, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Ptr:
+		return toObject(v.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return vm.Null, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			el, err := toObject(v.Index(i), seen)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %s", i, err)
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return lessKey(keys[i], keys[j]) })
+		hash := object.NewHash(len(keys))
+		for _, k := range keys {
+			key, err := toObject(k, seen)
+			if err != nil {
+				return nil, fmt.Errorf("key %v: %s", k, err)
+			}
+			value, err := toObject(v.MapIndex(k), seen)
+			if err != nil {
+				return nil, fmt.Errorf("[%v]: %s", k, err)
+			}
+			err = hash.Set(key, value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.Struct:
+		t := v.Type()
+		hash := object.NewHash(t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			value, err := toObject(v.Field(i), seen)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+			hash.Set(&object.String{Value: name}, value)
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return vm.Null, nil
+		}
+		return funcToBuiltin(v), nil
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Monkey object", v.Type())
+	}
+}
+
+// fieldName returns the hash key of a struct field and whether the field
+// is converted at all.
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" { // unexported
+		return "", false
+	}
+	tag := f.Tag.Get("monkey")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return f.Name, true
+}
+
+func funcToBuiltin(fn reflect.Value) *object.Builtin {
+	t := fn.Type()
+
+	return &object.Builtin{Fn: func(args ...object.Object) (object.Object, error) {
+		numIn := t.NumIn()
+		if t.IsVariadic() {
+			if len(args) < numIn-1 {
+				return nil, fmt.Errorf("wrong number of arguments. got=%d, want at least %d", len(args), numIn-1)
+			}
+		} else if len(args) != numIn {
+			return nil, fmt.Errorf("wrong number of arguments. got=%d, want=%d", len(args), numIn)
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var paramType reflect.Type
+			if t.IsVariadic() && i >= numIn-1 {
+				paramType = t.In(numIn - 1).Elem()
+			} else {
+				paramType = t.In(i)
+			}
+
+			param := reflect.New(paramType).Elem()
+			err := fromObject(arg, param, map[object.Object]bool{})
+			if err != nil {
+				return nil, fmt.Errorf("argument %d: %s", i+1, err)
+			}
+			in[i] = param
+		}
+
+		out := fn.Call(in)
+
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err := out[n-1]; !err.IsNil() {
+				return nil, err.Interface().(error)
+			}
+			out = out[:n-1]
+		}
+
+		results := make([]object.Object, len(out))
+		for i, v := range out {
+			result, err := toObject(v, map[visit]bool{})
+			if err != nil {
+				return nil, fmt.Errorf("result %d: %s", i+1, err)
+			}
+			results[i] = result
+		}
+
+		switch len(results) {
+		case 0:
+			return vm.Null, nil
+		case 1:
+			return results[0], nil
+		default:
+			return &object.Array{Elements: results}, nil
+		}
+	}}
+}
+
+// FromObject stores obj in the value target points to, converting it to
+// the target's type with the reverse rules of ToObject. HASH objects are
+// converted to structs by matching keys against the field names.
+//
+// When target points to an interface{}, obj is converted to a natural Go
+// value: int64, float64, string, bool, nil, []interface{} or
+// map[string]interface{} (map[interface{}]interface{} when a key is not
+// a STRING). Functions are kept as object.Object.
+func FromObject(obj object.Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("FromObject needs a non-nil pointer, got %T", target)
+	}
+	return fromObject(obj, v.Elem(), map[object.Object]bool{})
+}
+
+// fromObject stores obj in v, seen holds the arrays and hashes being
+// converted further up, so that a container holding itself fails instead of
+// recursing forever.
+func fromObject(obj object.Object, v reflect.Value, seen map[object.Object]bool) error {
+	if obj == nil {
+		// an unset global or a builtin returning nothing stands for null
+		obj = vm.Null
+	}
+
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		goValue, err := naturalValue(obj, seen)
+		if err != nil {
+			return err
+		}
+		if goValue == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(goValue))
+		}
+		return nil
+	}
+
+	if reflect.TypeOf(obj).AssignableTo(v.Type()) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if _, ok := obj.(*object.Null); ok {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		err := fromObject(obj, elem.Elem(), seen)
+		if err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("cannot convert %s to %s", obj.Type(), v.Type())
+	}
+
+	switch obj := obj.(type) {
+	case *object.Integer:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(obj.Value) {
+				return fmt.Errorf("%d overflows %s", obj.Value, v.Type())
+			}
+			v.SetInt(obj.Value)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if obj.Value < 0 || v.OverflowUint(uint64(obj.Value)) {
+				return fmt.Errorf("%d overflows %s", obj.Value, v.Type())
+			}
+			v.SetUint(uint64(obj.Value))
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(obj.Value))
+		default:
+			return mismatch()
+		}
+	case *object.Float:
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			return mismatch()
+		}
+		v.SetFloat(obj.Value)
+	case *object.String:
+		if v.Kind() != reflect.String {
+			return mismatch()
+		}
+		v.SetString(obj.Value)
+	case *object.Boolean:
+		if v.Kind() != reflect.Bool {
+			return mismatch()
+		}
+		v.SetBool(obj.Value)
+	case *object.Null:
+		switch v.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Interface:
+			v.Set(reflect.Zero(v.Type()))
+		default:
+			return mismatch()
+		}
+	case *object.Array:
+		if seen[obj] {
+			return errContainsItself(obj)
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		return arrayFromObject(obj, v, mismatch, seen)
+	case *object.Hash:
+		if seen[obj] {
+			return errContainsItself(obj)
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		return hashFromObject(obj, v, mismatch, seen)
+	default:
+		return mismatch()
+	}
+
+	return nil
+}
+
+func arrayFromObject(arr *object.Array, v reflect.Value, mismatch func() error, seen map[object.Object]bool) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), len(arr.Elements), len(arr.Elements)))
+	case reflect.Array:
+		if v.Len() != len(arr.Elements) {
+			return fmt.Errorf("cannot convert ARRAY of length %d to %s", len(arr.Elements), v.Type())
+		}
+	default:
+		return mismatch()
+	}
+
+	for i, el := range arr.Elements {
+		err := fromObject(el, v.Index(i), seen)
+		if err != nil {
+			return fmt.Errorf("[%d]: %s", i, err)
+		}
+	}
+	return nil
+}
+
+func hashFromObject(hash *object.Hash, v reflect.Value, mismatch func() error, seen map[object.Object]bool) error {
+	switch v.Kind() {
+	case reflect.Map:
+		m := reflect.MakeMapWithSize(v.Type(), hash.Len())
+		for _, pair := range hash.Pairs() {
+			key := reflect.New(v.Type().Key()).Elem()
+			err := fromObject(pair.Key, key, seen)
+			if err != nil {
+				return fmt.Errorf("key %s: %s", pair.Key.Inspect(), err)
+			}
+			if !comparable(key) {
+				return fmt.Errorf("key %s: cannot use %s as a map key", pair.Key.Inspect(), pair.Key.Type())
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			err = fromObject(pair.Value, value, seen)
+			if err != nil {
+				return fmt.Errorf("[%s]: %s", pair.Key.Inspect(), err)
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			value, ok, _ := hash.Get(&object.String{Value: name})
+			if !ok {
+				continue
+			}
+			err := fromObject(value, v.Field(i), seen)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+// naturalValue converts obj to the Go value FromObject stores in an interface{}.
+func naturalValue(obj object.Object, seen map[object.Object]bool) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Null:
+		return nil, nil
+	case *object.Array:
+		if seen[obj] {
+			return nil, errContainsItself(obj)
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		values := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			value, err := naturalValue(el, seen)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case *object.Hash:
+		if seen[obj] {
+			return nil, errContainsItself(obj)
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		allStrings := true
+		pairs := obj.Pairs()
+		for _, pair := range pairs {
+			if _, ok := pair.Key.(*object.String); !ok {
+				allStrings = false
+			}
+		}
+
+		if allStrings {
+			m := make(map[string]interface{}, len(pairs))
+			for _, pair := range pairs {
+				value, err := naturalValue(pair.Value, seen)
+				if err != nil {
+					return nil, err
+				}
+				m[pair.Key.(*object.String).Value] = value
+			}
+			return m, nil
+		}
+
+		m := make(map[interface{}]interface{}, len(pairs))
+		for _, pair := range pairs {
+			key, err := naturalValue(pair.Key, seen)
+			if err != nil {
+				return nil, err
+			}
+			if !comparable(reflect.ValueOf(key)) {
+				return nil, fmt.Errorf("key %s: cannot use %s as a map key", pair.Key.Inspect(), pair.Key.Type())
+			}
+			value, err := naturalValue(pair.Value, seen)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	case *object.Error:
+		return nil, fmt.Errorf("%s", obj.Message)
+	default:
+		return obj, nil
+	}
+}
+
+func errContainsItself(obj object.Object) error {
+	return fmt.Errorf("cannot convert %s containing itself", obj.Type())
+}
+
+// lessKey orders Go map keys so that converted hashes do not depend on map
+// iteration order. Numbers and strings sort by value, other keys by their
+// formatted value.
+func lessKey(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	if b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	if a.IsValid() && b.IsValid() && a.Kind() == b.Kind() {
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// comparable reports whether v can be a Go map key. Array keys of hashes
+// convert to slices, which cannot.
+func comparable(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	return !v.IsValid() || v.Type().Comparable()
+}
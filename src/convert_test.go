@@ -0,0 +1,353 @@
+package monkey
+
+import (
+	"errors"
+	"monkey/object"
+	"monkey/vm"
+	"reflect"
+	"testing"
+)
+
+type account struct {
+	Name    string            `monkey:"name"`
+	Balance float64           `monkey:"balance"`
+	Tags    []string          `monkey:"tags"`
+	Limits  map[string]int    `monkey:"limits"`
+	Owner   *account          `monkey:"owner"`
+	Secret  string            `monkey:"-"`
+	Extra   map[string]string // no tag, keyed by the field name
+	hidden  int
+}
+
+func TestToObject(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{42, "42"},
+		{int8(-3), "-3"},
+		{uint16(7), "7"},
+		{2.5, "2.5"},
+		{float32(0.5), "0.5"},
+		{"monkey", "monkey"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{map[int]string{1: "a"}, "{1: a}"},
+		{map[string]int{"b": 2, "c": 3, "a": 1}, "{a: 1, b: 2, c: 3}"},
+		{map[int]bool{10: true, -1: false, 9: true}, "{-1: false, 9: true, 10: true}"},
+		{(*account)(nil), "null"},
+		{[]int(nil), "null"},
+		{&object.Integer{Value: 9}, "9"},
+		{struct{ X object.Object }{}, "{X: null}"},
+		{[]object.Object{nil, (*object.String)(nil)}, "[null, null]"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("ToObject(%#v) failed: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("ToObject(%#v) = %s, want %s", tt.input, obj.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestToObjectStruct(t *testing.T) {
+	acc := account{
+		Name:    "alice",
+		Balance: 10.5,
+		Tags:    []string{"vip"},
+		Owner:   &account{Name: "bob"},
+		Secret:  "s3cret",
+		hidden:  1,
+	}
+
+	obj, err := ToObject(acc)
+	if err != nil {
+		t.Fatalf("ToObject failed: %s", err)
+	}
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		t.Fatalf("not a hash. got=%T", obj)
+	}
+
+	get := func(h *object.Hash, key string) object.Object {
+		value, ok, _ := h.Get(&object.String{Value: key})
+		if !ok {
+			return nil
+		}
+		return value
+	}
+
+	if v := get(hash, "name"); v == nil || v.Inspect() != "alice" {
+		t.Errorf("wrong name: %v", v)
+	}
+	if v := get(hash, "tags"); v == nil || v.Inspect() != "[vip]" {
+		t.Errorf("wrong tags: %v", v)
+	}
+	if v := get(hash, "limits"); v == nil || v.Type() != object.NULL_OBJ {
+		t.Errorf("wrong limits: %v", v)
+	}
+	if v := get(hash, "Extra"); v == nil {
+		t.Errorf("untagged field is missing")
+	}
+	owner, ok := get(hash, "owner").(*object.Hash)
+	if !ok || get(owner, "name").Inspect() != "bob" {
+		t.Errorf("wrong owner: %v", get(hash, "owner"))
+	}
+	for _, key := range []string{"Secret", "-", "hidden"} {
+		if get(hash, key) != nil {
+			t.Errorf("field %s should not be converted", key)
+		}
+	}
+}
+
+func TestToObjectErrors(t *testing.T) {
+	tests := []interface{}{
+		make(chan int),
+		uint64(1 << 63),
+		map[string]chan int{"a": nil},
+		[]complex64{1},
+	}
+
+	for _, tt := range tests {
+		if _, err := ToObject(tt); err == nil {
+			t.Errorf("ToObject(%T) should fail", tt)
+		}
+	}
+}
+
+type node struct {
+	Next *node
+	Kids []interface{}
+}
+
+func TestToObjectCycles(t *testing.T) {
+	n := &node{}
+	n.Next = n
+	kids := []interface{}{nil}
+	kids[0] = kids
+	m := map[string]interface{}{}
+	m["m"] = m
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{n, "Next: cannot convert *monkey.node containing itself"},
+		{&node{Kids: kids}, "Kids: [0]: cannot convert []interface {} containing itself"},
+		{m, "[m]: cannot convert map[string]interface {} containing itself"},
+	}
+
+	for _, tt := range tests {
+		_, err := ToObject(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	// The same value twice is not a cycle.
+	shared := &node{}
+	obj, err := ToObject([]*node{shared, shared, {Next: shared}})
+	if err != nil {
+		t.Fatalf("ToObject failed: %s", err)
+	}
+	expected := "[{Next: null, Kids: null}, {Next: null, Kids: null}, {Next: {Next: null, Kids: null}, Kids: null}]"
+	if obj.Inspect() != expected {
+		t.Errorf("ToObject = %s, want %s", obj.Inspect(), expected)
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	engine := New()
+	err := engine.Load("data.mk", `
+let data = {
+	"name": "alice",
+	"balance": 10,
+	"tags": ["a", "b"],
+	"limits": {"daily": 5},
+	"owner": {"name": "bob"},
+	"Extra": {"k": "v"}
+};
+let mixed = [1, 2.5, "s", true, {1: 2}, {"a": [if (false) { 1 }]}];
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := engine.Get("data")
+	var acc account
+	err = FromObject(data, &acc)
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	expected := account{
+		Name:    "alice",
+		Balance: 10,
+		Tags:    []string{"a", "b"},
+		Limits:  map[string]int{"daily": 5},
+		Owner:   &account{Name: "bob"},
+		Extra:   map[string]string{"k": "v"},
+	}
+	if !reflect.DeepEqual(acc, expected) {
+		t.Errorf("wrong struct.\nwant=%+v\ngot =%+v", expected, acc)
+	}
+
+	mixed, _ := engine.Get("mixed")
+	var values interface{}
+	err = FromObject(mixed, &values)
+	if err != nil {
+		t.Fatalf("FromObject failed: %s", err)
+	}
+	expectedValues := []interface{}{
+		int64(1), 2.5, "s", true,
+		map[interface{}]interface{}{int64(1): int64(2)},
+		map[string]interface{}{"a": []interface{}{nil}},
+	}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("wrong values.\nwant=%#v\ngot =%#v", expectedValues, values)
+	}
+
+	var obj object.Object
+	if err := FromObject(data, &obj); err != nil || obj != data {
+		t.Errorf("object.Object target should get the object itself: %v", err)
+	}
+
+	s, p := "unchanged", new(int)
+	if err := FromObject(nil, &p); err != nil || p != nil {
+		t.Errorf("nil should convert to a nil pointer: %v", err)
+	}
+	if err := FromObject(nil, &values); err != nil || values != nil {
+		t.Errorf("nil should convert to a nil interface{}: %v", err)
+	}
+	if err := FromObject(nil, &obj); err != nil || obj != vm.Null {
+		t.Errorf("nil should convert to null: %v", err)
+	}
+	if err := FromObject(nil, &s); err == nil || err.Error() != "cannot convert NULL to string" {
+		t.Errorf("wrong error for nil: %v", err)
+	}
+}
+
+func TestFromObjectErrors(t *testing.T) {
+	var i int8
+	var s string
+	var u uint
+	var arr [1]int
+	var natural interface{}
+	var nested [][]int
+
+	cyclic := &object.Array{Elements: []object.Object{nil}}
+	cyclic.Elements[0] = cyclic
+	owner := object.NewHash(1)
+	owner.Set(&object.String{Value: "owner"}, owner)
+
+	arrayKeys := object.NewHash(1)
+	arrayKeys.Set(&object.Array{Elements: []object.Object{&object.Integer{Value: 1}}}, &object.Integer{Value: 1})
+
+	tests := []struct {
+		obj      object.Object
+		target   interface{}
+		expected string
+	}{
+		{&object.Integer{Value: 1000}, &i, "1000 overflows int8"},
+		{&object.Integer{Value: -1}, &u, "-1 overflows uint"},
+		{&object.Integer{Value: 1}, &s, "cannot convert INTEGER to string"},
+		{&object.Array{Elements: []object.Object{}}, &arr, "cannot convert ARRAY of length 0 to [1]int"},
+		{&object.Array{Elements: []object.Object{&object.String{Value: "x"}}}, &[]int{}, "[0]: cannot convert STRING to int"},
+		{&object.Integer{Value: 1}, s, "FromObject needs a non-nil pointer, got string"},
+		{arrayKeys, &natural, "key [1]: cannot use ARRAY as a map key"},
+		{arrayKeys, &map[interface{}]int{}, "key [1]: cannot use ARRAY as a map key"},
+		{cyclic, &natural, "cannot convert ARRAY containing itself"},
+		{cyclic, &nested, "[0]: cannot convert ARRAY containing itself"},
+		{owner, &account{}, "owner: cannot convert HASH containing itself"},
+	}
+
+	for _, tt := range tests {
+		err := FromObject(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	engine := New()
+
+	err := engine.RegisterFunc("greet", func(name string, times int) string {
+		out := ""
+		for i := 0; i < times; i++ {
+			out += "hi " + name + "!"
+		}
+		return out
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.RegisterFunc("divmod", func(a, b int) (int, int, error) {
+		if b == 0 {
+			return 0, 0, errors.New("division by zero")
+		}
+		return a / b, a % b, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.RegisterFunc("sum", func(xs ...float64) float64 {
+		total := 0.0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.RegisterFunc("nothing", func() object.Object { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.RegisterFunc("bad", 42); err == nil {
+		t.Errorf("registering a non-func should fail")
+	}
+
+	err = engine.Load("funcs.mk", `
+let a = greet("bob", 2);
+let b = divmod(7, 2);
+let d = sum(1, 2.5, 3);
+let e = nothing();
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"a": "hi bob!hi bob!",
+		"b": "[3, 1]",
+		"d": "6.5",
+		"e": "null",
+	}
+	for name, expected := range tests {
+		value, _ := engine.Get(name)
+		if value == nil || value.Inspect() != expected {
+			t.Errorf("%s = %v, want %s", name, value, expected)
+		}
+	}
+
+	errorTests := map[string]string{
+		`divmod(1, 0)`: "division by zero",
+		`greet(1, 2)`:  "argument 1: cannot convert INTEGER to string",
+		`greet("bob")`: "wrong number of arguments. got=1, want=2",
+	}
+	for input, expected := range errorTests {
+		err := engine.Load("error.mk", input)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: wrong error. want=%q, got=%v", input, expected, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package monkey embeds the Monkey language in Go programs.
+//
+//	engine := monkey.New()
+//	engine.Register("log", func(args ...object.Object) (object.Object, error) { ... })
+//	err := engine.Load("rules.mk", src)
+//	result, err := engine.Call("allow", "alice", 42)
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"reflect"
+	"strings"
+)
+
+// maxBuiltins is the number of builtins a wide OpGetBuiltin can address.
+const maxBuiltins = 1 << 16
+
+// Engine compiles and runs Monkey scripts that share one global scope,
+// lets Go call the functions they define and lets them call Go functions
+// registered with Register. An Engine is not safe for concurrent use.
+type Engine struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object // carried from one VM to the next, see vm.VM.Globals
+	builtins    []*object.Builtin
+	limits      vm.Limits
+
+	machine *vm.VM // the VM currently executing, nil when idle
+}
+
+// New returns an Engine with the default builtins.
+func New() *Engine {
+	symbolTable := compiler.NewSymbolTable()
+	builtins := make([]*object.Builtin, len(object.Builtins))
+	for i, def := range object.Builtins {
+		symbolTable.DefineBuiltin(i, def.Name)
+		builtins[i] = def.Builtin
+	}
+
+	return &Engine{
+		symbolTable: symbolTable,
+		constants:   []object.Object{},
+		builtins:    builtins,
+	}
+}
+
+// ParseErrors is returned by Load when the script does not parse.
+type ParseErrors []*parser.ParseError
+
+func (pe ParseErrors) Error() string {
+	msgs := make([]string, len(pe))
+	for i, err := range pe {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Register makes fn callable as a builtin named name by the scripts loaded
+// afterwards. Registering a name again replaces the function, also for
+// scripts that are already loaded. An error returned by fn stops the script,
+// Load or Call return it wrapped in a *vm.RuntimeError.
+func (e *Engine) Register(name string, fn object.BuiltinFunction) error {
+	builtin := &object.Builtin{Fn: fn}
+
+	symbol, ok := e.symbolTable.Resolve(name)
+	if ok && symbol.Scope == compiler.BuiltinScope {
+		e.builtins[symbol.Index] = builtin
+		return nil
+	}
+
+	if len(e.builtins) >= maxBuiltins {
+		return fmt.Errorf("cannot register %s: too many builtins", name)
+	}
+	e.symbolTable.DefineBuiltin(len(e.builtins), name)
+	e.builtins = append(e.builtins, builtin)
+	return nil
+}
+
+// RegisterFunc is like Register for any Go func, its arguments and results
+// are converted as described for ToObject.
+func (e *Engine) RegisterFunc(name string, fn interface{}) error {
+	if reflect.TypeOf(fn) == nil || reflect.TypeOf(fn).Kind() != reflect.Func {
+		return fmt.Errorf("cannot register %s: %T is not a func", name, fn)
+	}
+	obj, err := ToObject(fn)
+	if err != nil {
+		return err
+	}
+	builtin, ok := obj.(*object.Builtin)
+	if !ok {
+		return fmt.Errorf("cannot register %s: nil func", name)
+	}
+	return e.Register(name, builtin.Fn)
+}
+
+// SetLimits sets the budgets each Load and Call runs with, see vm.Limits.
+func (e *Engine) SetLimits(limits vm.Limits) {
+	e.limits = limits
+}
+
+// Load compiles src and runs its top level. The globals it defines stay
+// visible to Get, Call and the scripts loaded later. filename is only used
+// in error messages.
+func (e *Engine) Load(filename, src string) error {
+	return e.LoadContext(context.Background(), filename, src)
+}
+
+// LoadContext is like Load but stops the script once ctx is done.
+func (e *Engine) LoadContext(ctx context.Context, filename, src string) error {
+	l := lexer.NewWithFilename(filename, src)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return ParseErrors(p.Errors())
+	}
+
+	// compile against copies, so that a script that does not compile
+	// leaves no half-defined globals behind
+	symbolTable := e.symbolTable.Copy()
+	constants := e.constants[:len(e.constants):len(e.constants)]
+	comp := compiler.NewWithState(symbolTable, constants)
+	err := comp.Compile(program)
+	if err != nil {
+		return err
+	}
+
+	bytecode := comp.Bytecode()
+	e.symbolTable = symbolTable
+	e.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, e.currentGlobals())
+	machine.SetBuiltins(e.builtins)
+	machine.SetLimits(e.limits)
+
+	previous := e.machine
+	e.machine = machine
+	defer func() {
+		e.globals = machine.Globals()
+		e.machine = previous
+	}()
+
+	return machine.RunContext(ctx)
+}
+
+// Get returns the value of the global variable name.
+func (e *Engine) Get(name string) (object.Object, bool) {
+	symbol, ok := e.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		return nil, false
+	}
+
+	globals := e.currentGlobals()
+	if symbol.Index >= len(globals) {
+		return nil, false
+	}
+	value := globals[symbol.Index]
+	return value, value != nil
+}
+
+// currentGlobals returns the globals of the running VM, which may have
+// grown since it started, or the ones the last VM left.
+func (e *Engine) currentGlobals() []object.Object {
+	if e.machine != nil {
+		return e.machine.Globals()
+	}
+	return e.globals
+}
+
+// Call calls the global function name with args, see CallFunction.
+func (e *Engine) Call(name string, args ...interface{}) (object.Object, error) {
+	return e.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call but stops the function once ctx is done.
+func (e *Engine) CallContext(ctx context.Context, name string, args ...interface{}) (object.Object, error) {
+	fn, ok := e.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined function %s", name)
+	}
+	return e.callFunction(ctx, fn, args)
+}
+
+// CallFunction calls fn, a Monkey closure or builtin, with args converted
+// by ToObject. It may be used by registered functions while a script
+// is running, e.g. to call a callback they were passed; the call then
+// counts against the budgets and context of the running script.
+func (e *Engine) CallFunction(fn object.Object, args ...interface{}) (object.Object, error) {
+	return e.callFunction(context.Background(), fn, args)
+}
+
+func (e *Engine) callFunction(ctx context.Context, fn object.Object, args []interface{}) (object.Object, error) {
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", i, err)
+		}
+		objs[i] = obj
+	}
+
+	if e.machine != nil {
+		e.machine.SetBuiltins(e.builtins)
+		return e.machine.Call(fn, objs...)
+	}
+
+	machine := vm.NewWithGlobalsStore(&compiler.Bytecode{Constants: e.constants}, e.globals)
+	machine.SetBuiltins(e.builtins)
+	machine.SetLimits(e.limits)
+	e.machine = machine
+	defer func() {
+		e.globals = machine.Globals()
+		e.machine = nil
+	}()
+
+	return machine.CallContext(ctx, fn, objs...)
+}
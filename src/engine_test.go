@@ -0,0 +1,303 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"monkey/object"
+	"monkey/vm"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineCall(t *testing.T) {
+	engine := New()
+	err := engine.Load("rules.mk", `
+let limit = 10;
+let allow = fn(user, amount) { if (user == "root") { return true; } amount <= limit };
+`)
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+
+	tests := []struct {
+		user     interface{}
+		amount   interface{}
+		expected bool
+	}{
+		{"alice", 5, true},
+		{"alice", 50, false},
+		{"alice", 10.5, false},
+	}
+
+	for _, tt := range tests {
+		result, err := engine.Call("allow", tt.user, tt.amount)
+		if err != nil {
+			t.Fatalf("Call failed: %s", err)
+		}
+		b, ok := result.(*object.Boolean)
+		if !ok || b.Value != tt.expected {
+			t.Errorf("allow(%v, %v) = %s, want %t", tt.user, tt.amount, result.Inspect(), tt.expected)
+		}
+	}
+
+	limit, ok := engine.Get("limit")
+	if !ok || limit.Inspect() != "10" {
+		t.Errorf("wrong global limit: %v", limit)
+	}
+	if _, ok := engine.Get("nope"); ok {
+		t.Errorf("Get found an undefined global")
+	}
+}
+
+func TestEngineLoadKeepsGlobals(t *testing.T) {
+	engine := New()
+	if err := engine.Load("a.mk", `let counter = 0; let inc = fn() { counter += 1 };`); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Load("b.mk", `inc(); inc();`); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.Call("inc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inspect() != "3" {
+		t.Errorf("wrong counter. want=3, got=%s", result.Inspect())
+	}
+}
+
+func TestEngineRegister(t *testing.T) {
+	engine := New()
+
+	var logged []string
+	err := engine.Register("log", func(args ...object.Object) (object.Object, error) {
+		for _, arg := range args {
+			logged = append(logged, arg.Inspect())
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.Register("twice", func(args ...object.Object) (object.Object, error) {
+		n := args[0].(*object.Integer).Value
+		return &object.Integer{Value: 2 * n}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.Load("host.mk", `log("start", twice(21)); let f = fn() { log(len([1, 2])) }; f();`)
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+
+	expected := []string{"start", "42", "2"}
+	if strings.Join(logged, ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong log. want=%v, got=%v", expected, logged)
+	}
+
+	// registering again replaces the function for code already loaded
+	err = engine.Register("twice", func(args ...object.Object) (object.Object, error) {
+		return &object.Integer{Value: -1}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Load("again.mk", `let g = fn() { twice(1) };`); err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Call("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inspect() != "-1" {
+		t.Errorf("twice was not replaced, got=%s", result.Inspect())
+	}
+}
+
+func TestEngineReentrantCalls(t *testing.T) {
+	engine := New()
+
+	// each(arr, f) calls back into Monkey for every element
+	err := engine.Register("each", func(args ...object.Object) (object.Object, error) {
+		arr := args[0].(*object.Array)
+		for _, el := range arr.Elements {
+			_, err := engine.CallFunction(args[1], el)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.Load("each.mk", `
+let sum = fn(arr) {
+	let total = 0;
+	each(arr, fn(x) { each([1, 2], fn(y) { total += x * y }) });
+	total
+};
+let top = sum([1, 2, 3]);
+`)
+	if err != nil {
+		t.Fatalf("Load failed: %s", err)
+	}
+
+	top, _ := engine.Get("top")
+	if top.Inspect() != "18" {
+		t.Errorf("wrong top-level result. want=18, got=%s", top.Inspect())
+	}
+
+	result, err := engine.Call("sum", &object.Array{Elements: []object.Object{&object.Integer{Value: 10}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inspect() != "30" {
+		t.Errorf("wrong result. want=30, got=%s", result.Inspect())
+	}
+}
+
+func TestEngineFailedLoad(t *testing.T) {
+	engine := New()
+	if err := engine.Load("a.mk", `let x = 1;`); err != nil {
+		t.Fatal(err)
+	}
+
+	// a script that does not compile defines nothing
+	if err := engine.Load("bad.mk", `let y = fn() { 2 }; let z = undefinedVar;`); err == nil {
+		t.Fatalf("expected a compile error")
+	}
+	if _, ok := engine.Get("y"); ok {
+		t.Errorf("y should not be defined")
+	}
+	if err := engine.Load("use.mk", `y + 1`); err == nil || !strings.Contains(err.Error(), "undefined variable y") {
+		t.Errorf("wrong error for y: %v", err)
+	}
+
+	if err := engine.Load("good.mk", `let y = x + 1; let z = fn() { y * 10 };`); err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Call("z")
+	if err != nil || result.Inspect() != "20" {
+		t.Errorf("wrong result after a failed load: %v, %v", result, err)
+	}
+}
+
+func TestEngineErrors(t *testing.T) {
+	engine := New()
+
+	err := engine.Load("bad.mk", `let = 1;`)
+	var parseErrs ParseErrors
+	if !errors.As(err, &parseErrs) || parseErrs.Error() != "bad.mk:1:5: expected next token to be IDENT, got = instead" {
+		t.Errorf("wrong parse error: %v", err)
+	}
+
+	if err := engine.Load("ok.mk", `let f = fn(x) { x + "a" };`); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = engine.Call("f", 1)
+	var runtimeErr *vm.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *vm.RuntimeError, got=%T (%v)", err, err)
+	}
+	if runtimeErr.Frames[0].Function != "f" || runtimeErr.Pos().String() != "ok.mk:1:19" {
+		t.Errorf("wrong traceback: %s", runtimeErr.Traceback())
+	}
+
+	// the engine is still usable after a failed call
+	result, err := engine.Call("f", "b")
+	if err != nil || result.Inspect() != "ba" {
+		t.Errorf("call after error failed: %v, %v", result, err)
+	}
+
+	if _, err := engine.Call("missing"); err == nil || err.Error() != "undefined function missing" {
+		t.Errorf("wrong error for a missing function: %v", err)
+	}
+	if _, err := engine.Call("f", struct{}{}); err == nil {
+		t.Errorf("expected a conversion error")
+	}
+	if _, err := engine.Call("f", 1, 2); err == nil {
+		t.Errorf("expected a wrong number of arguments error")
+	}
+
+	// reading a global before it is set stops the script, not the host
+	err = engine.Load("unset.mk", `let z = z + 1;`)
+	if !errors.As(err, &runtimeErr) || runtimeErr.Err.Error() != "variable used before it is set" {
+		t.Errorf("wrong error for an unset global: %v", err)
+	}
+	if err := engine.Load("unset.mk", `z + 1`); !errors.As(err, &runtimeErr) {
+		t.Errorf("wrong error for an unset global: %v", err)
+	}
+}
+
+func TestEngineLimits(t *testing.T) {
+	engine := New()
+	engine.SetLimits(vm.Limits{MaxInstructions: 10000, MaxCallDepth: 50})
+
+	err := engine.Load("loop.mk", `while (true) { }`)
+	if !errors.Is(err, vm.ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got=%v", err)
+	}
+
+	if err := engine.Load("rec.mk", `let f = fn() { f() }; let spin = fn() { while (true) { } };`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Call("f"); !errors.Is(err, vm.ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got=%v", err)
+	}
+
+	engine.SetLimits(vm.Limits{MaxMemory: 1 << 20})
+	if err := engine.Load("mem.mk", `let s = "x"; while (true) { s = s + s; }`); !errors.Is(err, vm.ErrMemoryExceeded) {
+		t.Errorf("expected ErrMemoryExceeded, got=%v", err)
+	}
+
+	engine.SetLimits(vm.Limits{})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := engine.CallContext(ctx, "spin"); !errors.Is(err, vm.ErrCanceled) {
+		t.Errorf("expected ErrCanceled, got=%v", err)
+	}
+	if err := engine.LoadContext(ctx, "spin.mk", `spin()`); !errors.Is(err, vm.ErrCanceled) {
+		t.Errorf("expected ErrCanceled, got=%v", err)
+	}
+}
+
+func TestEngineBuiltinErrors(t *testing.T) {
+	engine := New()
+
+	errDenied := errors.New("access denied")
+	err := engine.Register("open", func(args ...object.Object) (object.Object, error) {
+		if args[0].Inspect() == "/etc/passwd" {
+			return nil, errDenied
+		}
+		return &object.String{Value: "contents"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = engine.Load("open.mk", `let read = fn(path) { open(path) };`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := engine.Call("read", "notes.txt")
+	if err != nil || result.Inspect() != "contents" {
+		t.Errorf("read failed: %v, %v", result, err)
+	}
+
+	_, err = engine.Call("read", "/etc/passwd")
+	var runtimeErr *vm.RuntimeError
+	if !errors.Is(err, errDenied) || !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected the builtin's error, got=%v", err)
+	}
+	if runtimeErr.Frames[0].Function != "read" {
+		t.Errorf("wrong traceback: %s", runtimeErr.Traceback())
+	}
+}
@@ -5,10 +5,18 @@ import (
 )
 
 var builtins = map[string]*object.Builtin{
-	"len":   object.GetBuiltinByName("len"),
-	"puts":  object.GetBuiltinByName("puts"),
-	"first": object.GetBuiltinByName("first"),
-	"last":  object.GetBuiltinByName("last"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"push":  object.GetBuiltinByName("push"),
+	"len":    object.GetBuiltinByName("len"),
+	"puts":   object.GetBuiltinByName("puts"),
+	"first":  object.GetBuiltinByName("first"),
+	"last":   object.GetBuiltinByName("last"),
+	"rest":   object.GetBuiltinByName("rest"),
+	"push":   object.GetBuiltinByName("push"),
+	"int":    object.GetBuiltinByName("int"),
+	"float":  object.GetBuiltinByName("float"),
+	"abs":    object.GetBuiltinByName("abs"),
+	"floor":  object.GetBuiltinByName("floor"),
+	"ceil":   object.GetBuiltinByName("ceil"),
+	"sqrt":   object.GetBuiltinByName("sqrt"),
+	"keys":   object.GetBuiltinByName("keys"),
+	"values": object.GetBuiltinByName("values"),
 }
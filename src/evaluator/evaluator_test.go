@@ -27,6 +27,8 @@ func TestEvalIntegerExpression(t *testing.T) {
 		{"3 * 3 * 3 + 10", 37},
 		{"3 * (3 * 3) + 10", 37},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"2 + 10 % 4 * 3", 8},
 	}
 
 	for _, tt := range tests {
@@ -35,6 +37,47 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999},
+		{"float(3)", 3},
+		{"sqrt(16)", 4},
+		{"abs(-1.25)", 1.25},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestEvalMixedNumberComparisons(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1.5 < 2", true},
+		{"1.5 > 2", false},
+		{"2 == 2.0", true},
+		{"2 != 2.5", true},
+		{"0.1 + 0.2 > 0.3", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -59,6 +102,42 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 >= 4", false},
+		{"2.5 >= 2", true},
+		{"true && false", false},
+		{"1 && 2", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"false && undefined", false},
+		{"true || undefined", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "b"]] == [1, [2, "b"]]`, true},
+		{`[1] == [1.0]`, true},
+		{`{"a": [1], "b": 2} == {"b": 2, "a": [1]}`, true},
+		{`{"a": 1} != {"a": 2}`, true},
+		{`(if (false) { 1 }) == (if (false) { 2 })`, true},
+		{`"1" == 1`, false},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
 	}
 
 	for _, tt := range tests {
@@ -217,6 +296,10 @@ if (10 > 1) {
 			`{"name": "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`let a = [1]; a[0] = a; {a: 1}`,
+			"unusable as hash key: ARRAY containing itself",
+		},
 		{
 			`999[1]`,
 			"index operator not supported: INTEGER",
@@ -328,6 +411,188 @@ addTwo(2);`
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestRecursiveClosures(t *testing.T) {
+	input := `
+let wrapper = fn() {
+  let countDown = fn(x) { if (x == 0) { 0 } else { countDown(x - 1) } };
+  countDown(5);
+};
+wrapper();`
+
+	testIntegerObject(t, testEval(input), 0)
+}
+
+func TestReassignedRecursiveClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{`let f = fn() { f() }; let g = f; f = fn() { 0 }; g();`, 0},
+		{`let wrapper = fn() { let f = fn() { f() }; let g = f; f = fn() { 1 }; g() }; wrapper();`, 1},
+		{`let wrapper = fn() { let f = fn() { f() }; let g = f; let f = fn() { 2 }; g() }; wrapper();`, 2},
+		{`let wrapper = fn() { let f = fn(x) { if (x == 0) { f = fn(x) { 3 }; } f(x - 1) }; f(1) }; wrapper();`, 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`
+		let find = fn(arr, n) {
+			for (x in arr) {
+				if (x < n) { continue; }
+				if (x == n) { return x * 10; }
+				break;
+			}
+			-1
+		};
+		find([1, 2, 3], 2) + find([1, 5, 3], 3)
+		`, 19},
+		{`
+		let product = fn() {
+			for (x in [1, 2, 3]) {
+				for (y in [10, 20]) {
+					if (x * y == 40) { return x + y; }
+				}
+			}
+		};
+		product()
+		`, 22},
+		{`let f = fn() { while (true) { break; } 7 }; f()`, 7},
+		{`let loop = fn() { while (true) { return 42; } }; loop()`, 42},
+		{`while (false) { 1 }`, nil},
+		{`break;`, "break outside loop"},
+		{`for (x in 1) { x }`, "cannot iterate over INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let r = 0; try { throw 5; r = 1; } catch (e) { r = e; } r`, 5},
+		{`let r = 0; try { 1 + "a"; } catch (e) { r = e; } r`, "type mismatch: INTEGER + STRING"},
+		{`let r = 0; try { len(1); } catch (e) { r = e; } r`,
+			"argument to `len` not supported, got INTEGER"},
+		{`let r = 0; try { r = 1; } finally { r = r + 1; } r`, 2},
+		{`
+		let r = 0;
+		try { try { throw 1; } finally { r = 10; } } catch (e) { r = r + e; }
+		r
+		`, 11},
+		{`
+		let r = 0;
+		try { try { throw 1; } catch (e) { throw e + 1; } } catch (e) { r = e; }
+		r
+		`, 2},
+		{`
+		let f = fn(n) { if (n == 0) { throw "bottom"; } f(n - 1) };
+		let g = fn() { try { f(10) } catch (e) { return e; } };
+		g()
+		`, "bottom"},
+		{`let f = fn() { try { return 1; } finally { return 2; } }; f()`, 2},
+		{`
+		let f = fn() {
+			let r = 0;
+			for (x in [1, 2, 3, 4]) {
+				try {
+					if (x == 2) { continue; }
+					if (x == 4) { break; }
+					r = r + x;
+				} finally {
+					r = r + x * 10;
+				}
+			}
+			r
+		};
+		f()
+		`, 104},
+		{`try { 1 } catch (e) { 2 }`, nil},
+		{`throw 1 + 1;`, "ERROR: uncaught exception: 2"},
+		{`try { throw 1; } finally { 2 }`, "ERROR: uncaught exception: 1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated == nil || evaluated.Inspect() != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%v", tt.input, expected, evaluated)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let x = 1; x = 2; x`, 2},
+		{`let x = 1; x += 2`, 3},
+		{`let x = 10; x -= 3; x *= 2; x /= 7; x`, 2},
+		{`let a = [1, 2, 3]; a[1] = 5; a[1]`, 5},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{`
+		let counter = fn() { let n = 0; fn() { n += 1; n } };
+		let c = counter();
+		c(); c();
+		c() * 10 + counter()()
+		`, 31},
+		{`let i = 0; while (i < 5) { i += 1; } i`, 5},
+		{`x = 1`, "identifier not found: x"},
+		{`let a = [1]; a[1] = 2`, "index out of range: 1"},
+		{`let s = "a"; s[0] = "b"`, "index assignment is not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 
@@ -356,6 +621,44 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestStringOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"ab" * 3`, "ababab"},
+		{`"a" < "b"`, true},
+		{`"ab" < "abc"`, true},
+		{`"b" > "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
+		{`"a" in "cat"`, true},
+		{`"dog" in "cat"`, false},
+		{`2 in [1, 2, 3]`, true},
+		{`[2] in [1, [2]]`, true},
+		{`"k" in {"k": 1}`, true},
+		{`1 in {"1": 1}`, false},
+		{`1.0 in [1]`, true},
+		{`1.0 in {1: 0}`, true},
+		{`{1: "i", 1.0: "f"}`, "{1: f}"},
+		{`"ab" * -1`, "ERROR: negative string repetition count: -1"},
+		{`1 in "cat"`, "ERROR: left operand of in must be STRING, got INTEGER"},
+		{`1 in 2`, "ERROR: in operator is not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			if evaluated == nil || evaluated.Inspect() != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%v", tt.input, expected, evaluated)
+			}
+		}
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -379,6 +682,10 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`rest([])`, nil},
 		{`push([], 1)`, []int{1}},
 		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`keys({3: 1, 1: 2, 2: 3})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3})`, []int{1, 2, 3}},
+		{`let h = {2: 0, 1: 0}; h[3] = 0; h[2] = 0; keys(h)`, []int{2, 1, 3}},
+		{`keys([1])`, "argument to `keys` must be HASH, got ARRAY"},
 	}
 
 	for _, tt := range tests {
@@ -523,20 +830,58 @@ func TestHashLiterals(t *testing.T) {
 		FALSE.HashKey():                            6,
 	}
 
-	if len(result.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	if result.Len() != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", result.Len())
 	}
 
-	for expectedKey, expectedValue := range expected {
-		pair, ok := result.Pairs[expectedKey]
+	for _, pair := range result.Pairs() {
+		expectedValue, ok := expected[pair.Key.(object.Hashable).HashKey()]
 		if !ok {
-			t.Errorf("no pair for given key in Pairs")
+			t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
 		}
 
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{b: 4, a: 2, c: 3}"},
+		{`let out = ""; for (k in {"b": 1, "a": 2, "c": 3}) { out = out + k }; out`, "bac"},
+		{`values({"z": [1], "y": 2.5})`, "[[1], 2.5]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestSelfReferences(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; a[0] = a; a`, "[[...]]"},
+		{`let h = {}; h["self"] = h; h`, "{self: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; a`, "[{a: [...]}]"},
+		{`let b = [1]; let a = [b, b]; a`, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -570,6 +915,18 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{[1, "a"]: 5}[[1, "a"]]`,
+			5,
+		},
+		{
+			`{[1, "a"]: 5}[[1, "b"]]`,
+			nil,
+		},
+		{
+			`let k = [1]; let h = {k: 5}; k[0] = 2; h[[1]]`,
+			5,
+		},
 	}
 
 	for _, tt := range tests {
@@ -606,6 +963,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -1,24 +1,62 @@
 package lexer
 
-import "monkey/token"
+import (
+	"fmt"
+	"monkey/token"
+)
+
+// Error describes malformed input found by the lexer.
+type Error struct {
+	Token   token.Token // the malformed input
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Token.Pos, e.Message)
+}
 
 type Lexer struct {
+	filename     string
 	input        string
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           byte // current char under examination
+
+	line   int // line of the current char
+	column int // column of the current char
+
+	emitComments bool // return comments as token.COMMENT instead of skipping them
+
+	errors []*Error
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename creates a lexer whose token positions refer to filename.
+func NewWithFilename(filename string, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// SetEmitComments controls whether comments are returned as token.COMMENT
+// tokens (e.g. for formatters) or skipped like whitespace, which is the default.
+func (l *Lexer) SetEmitComments(emit bool) {
+	l.emitComments = emit
+}
+
+// Errors returns the malformed input found so far, in source order.
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	pos := l.currentPos()
 
 	switch l.ch {
 	case '=':
@@ -31,9 +69,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.newTokenOrEquals(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.newTokenOrEquals(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -44,13 +82,24 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.atComment() {
+			return l.readComment()
+		}
+		tok = l.newTokenOrEquals(token.SLASH, token.SLASH_ASSIGN)
+	case '#':
+		return l.readComment()
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.newTokenOrEquals(token.ASTERISK, token.ASTERISK_ASSIGN)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok = l.newTokenOrEquals(token.LT, token.LT_EQ)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok = l.newTokenOrEquals(token.GT, token.GT_EQ)
+	case '&':
+		tok = l.newDoubledToken(token.AND)
+	case '|':
+		tok = l.newDoubledToken(token.OR)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
@@ -79,10 +128,11 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos, tok.End = pos, l.currentPos()
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos, tok.End = pos, l.currentPos()
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
@@ -90,16 +140,81 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
+	tok.Pos, tok.End = pos, l.currentPos()
 	return tok
 }
 
+// currentPos returns the source position of the current char.
+func (l *Lexer) currentPos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+// skipWhitespace skips whitespace and, unless comments are emitted, comments.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case !l.emitComments && l.atComment():
+			l.readComment()
+		default:
+			return
+		}
+	}
+}
+
+// atComment reports whether a comment starts at the current char.
+func (l *Lexer) atComment() bool {
+	return l.ch == '#' || l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')
+}
+
+// readComment reads a `//` or `#` comment up to the end of the line, or a
+// `/* */` block comment. The literal includes the comment markers. A block
+// comment without its closing `*/` runs to the end of the input and is
+// recorded as an error.
+func (l *Lexer) readComment() token.Token {
+	pos := l.currentPos()
+	position := l.position
+	unterminated := false
+
+	if l.ch == '/' && l.peekChar() == '*' {
 		l.readChar()
+		l.readChar()
+		for !(l.ch == '*' && l.peekChar() == '/') && l.ch != 0 {
+			l.readChar()
+		}
+		if l.ch == 0 {
+			unterminated = true
+		} else {
+			l.readChar()
+			l.readChar()
+		}
+	} else {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
 	}
+
+	tok := token.Token{Type: token.COMMENT, Literal: l.input[position:l.position], Pos: pos, End: l.currentPos()}
+	if unterminated {
+		l.errors = append(l.errors, &Error{Token: tok, Message: "unterminated block comment"})
+	}
+	return tok
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -125,12 +240,43 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer, or a float when the digits are followed by a
+// fraction and/or an exponent: 3.14, 2e10, 1.5E-3.
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if (next == '+' || next == '-') && l.readPosition+1 < len(l.input) {
+			next = l.input[l.readPosition+1]
+		}
+
+		if isDigit(next) {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.ch == '+' || l.ch == '-' {
+				l.readChar()
+			}
+			l.readDigits()
+		}
+	}
+
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
 func (l *Lexer) readString() string {
@@ -152,6 +298,28 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// newTokenOrEquals returns the compound assignment token (e.g. +=) when the
+// current char is followed by '=', or the plain operator token otherwise.
+func (l *Lexer) newTokenOrEquals(op token.TokenType, withEquals token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Token{Type: withEquals, Literal: string(ch) + string(l.ch)}
+	}
+	return newToken(op, l.ch)
+}
+
+// newDoubledToken reads operators like "&&" made of the current char twice,
+// a single char is illegal.
+func (l *Lexer) newDoubledToken(tokenType token.TokenType) token.Token {
+	if l.peekChar() != l.ch {
+		return newToken(token.ILLEGAL, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -141,3 +141,281 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 2e10 1.5E-3 7e+2 1. 4e x1.0`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "2e10"},
+		{token.FLOAT, "1.5E-3"},
+		{token.FLOAT, "7e+2"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.INT, "4"},
+		{token.IDENT, "e"},
+		{token.IDENT, "x"},
+		{token.FLOAT, "1.0"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x = 1; x += 2; x -= 3; x *= 4; x /= 5;`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"}, {token.ASSIGN, "="}, {token.INT, "1"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.PLUS_ASSIGN, "+="}, {token.INT, "2"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.MINUS_ASSIGN, "-="}, {token.INT, "3"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.ASTERISK_ASSIGN, "*="}, {token.INT, "4"}, {token.SEMICOLON, ";"},
+		{token.IDENT, "x"}, {token.SLASH_ASSIGN, "/="}, {token.INT, "5"}, {token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndLogicalOperators(t *testing.T) {
+	input := `a <= b >= c % d && e || f & |`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.AND, "&&"},
+		{token.IDENT, "e"},
+		{token.OR, "||"},
+		{token.IDENT, "f"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  "ab" + x`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  string
+		expectedEnd  string
+	}{
+		{token.LET, "test.mk:1:1", "test.mk:1:4"},
+		{token.IDENT, "test.mk:1:5", "test.mk:1:6"},
+		{token.ASSIGN, "test.mk:1:7", "test.mk:1:8"},
+		{token.INT, "test.mk:1:9", "test.mk:1:10"},
+		{token.SEMICOLON, "test.mk:1:10", "test.mk:1:11"},
+		{token.STRING, "test.mk:2:3", "test.mk:2:7"},
+		{token.PLUS, "test.mk:2:8", "test.mk:2:9"},
+		{token.IDENT, "test.mk:2:10", "test.mk:2:11"},
+		{token.EOF, "test.mk:2:11", "test.mk:2:12"},
+	}
+
+	l := NewWithFilename("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%q, got=%q",
+				i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End.String() != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%q, got=%q",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; # trailing comment
+/* block
+   comment */ x / 2;
+3 /* 4`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.INT, "3"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestEmitComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; # trailing
+/* block
+   comment */ x`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedPos     string
+	}{
+		{token.COMMENT, "// leading comment", "1:1"},
+		{token.LET, "let", "2:1"},
+		{token.IDENT, "x", "2:5"},
+		{token.ASSIGN, "=", "2:7"},
+		{token.INT, "5", "2:9"},
+		{token.SEMICOLON, ";", "2:10"},
+		{token.COMMENT, "# trailing", "2:12"},
+		{token.COMMENT, "/* block\n   comment */", "3:1"},
+		{token.IDENT, "x", "4:15"},
+		{token.EOF, "", "4:16"},
+	}
+
+	l := New(input)
+	l.SetEmitComments(true)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%q, got=%q",
+				i, tt.expectedPos, tok.Pos)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	for _, emit := range []bool{false, true} {
+		l := New("1 /* a\n*")
+		l.SetEmitComments(emit)
+
+		tests := []struct {
+			expectedType    token.TokenType
+			expectedLiteral string
+			expectedPos     string
+		}{
+			{token.INT, "1", "1:1"},
+			{token.COMMENT, "/* a\n*", "1:3"},
+			{token.EOF, "", "2:2"},
+		}
+		if !emit {
+			tests = append(tests[:1], tests[2])
+		}
+
+		for i, tt := range tests {
+			tok := l.NextToken()
+			if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral ||
+				tok.Pos.String() != tt.expectedPos {
+				t.Fatalf("emit=%t tests[%d] - wrong token. expected=%s %q at %s, got=%s %q at %s",
+					emit, i, tt.expectedType, tt.expectedLiteral, tt.expectedPos,
+					tok.Type, tok.Literal, tok.Pos)
+			}
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("emit=%t - wrong number of errors. want=1, got=%d (%v)", emit, len(errors), errors)
+		}
+		if errors[0].Error() != "1:3: unterminated block comment" || errors[0].Token.Literal != "/* a\n*" {
+			t.Errorf("emit=%t - wrong error. got=%q for %q", emit, errors[0].Error(), errors[0].Token.Literal)
+		}
+	}
+}
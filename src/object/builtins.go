@@ -2,6 +2,8 @@ package object
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 )
 
 func GetBuiltinByName(name string) *Builtin {
@@ -19,19 +21,19 @@ var Builtins = []struct {
 }{
 	{
 		"len",
-		&Builtin{Fn: func(args ...Object) Object {
+		&Builtin{Fn: func(args ...Object) (Object, error) {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
+				return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
 					len(args))
 			}
 
 			switch arg := args[0].(type) {
 			case *Array:
-				return &Integer{Value: int64(len(arg.Elements))}
+				return &Integer{Value: int64(len(arg.Elements))}, nil
 			case *String:
-				return &Integer{Value: int64(len(arg.Value))}
+				return &Integer{Value: int64(len(arg.Value))}, nil
 			default:
-				return newError("argument to `len` not supported, got %s",
+				return nil, fmt.Errorf("argument to `len` not supported, got %s",
 					args[0].Type())
 			}
 		}},
@@ -39,70 +41,70 @@ var Builtins = []struct {
 	{
 		"puts",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(args ...Object) (Object, error) {
 				for _, arg := range args {
 					fmt.Println(arg.Inspect())
 				}
 
-				return nil
+				return nil, nil
 			},
 		},
 	},
 	{
 		"first",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(args ...Object) (Object, error) {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1",
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
 						len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `first` must be ARRAY, got %s",
+					return nil, fmt.Errorf("argument to `first` must be ARRAY, got %s",
 						args[0].Type())
 				}
 
 				arr := args[0].(*Array)
 				if len(arr.Elements) > 0 {
-					return arr.Elements[0]
+					return arr.Elements[0], nil
 				}
 
-				return nil
+				return nil, nil
 			},
 		},
 	},
 	{
 		"last",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(args ...Object) (Object, error) {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1",
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
 						len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `last` must be ARRAY, got %s",
+					return nil, fmt.Errorf("argument to `last` must be ARRAY, got %s",
 						args[0].Type())
 				}
 
 				arr := args[0].(*Array)
 				length := len(arr.Elements)
 				if length > 0 {
-					return arr.Elements[length-1]
+					return arr.Elements[length-1], nil
 				}
 
-				return nil
+				return nil, nil
 			},
 		},
 	},
 	{
 		"rest",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(args ...Object) (Object, error) {
 				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1",
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
 						len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `rest` must be ARRAY, got %s",
+					return nil, fmt.Errorf("argument to `rest` must be ARRAY, got %s",
 						args[0].Type())
 				}
 
@@ -111,10 +113,10 @@ var Builtins = []struct {
 				if length > 0 {
 					newElements := make([]Object, length-1, length-1)
 					copy(newElements, arr.Elements[1:length])
-					return &Array{Elements: newElements}
+					return &Array{Elements: newElements}, nil
 				}
 
-				return nil
+				return nil, nil
 			},
 		},
 	},
@@ -122,13 +124,13 @@ var Builtins = []struct {
 	{
 		"push",
 		&Builtin{
-			Fn: func(args ...Object) Object {
+			Fn: func(args ...Object) (Object, error) {
 				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=2",
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=2",
 						len(args))
 				}
 				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `push` must be ARRAY, got %s",
+					return nil, fmt.Errorf("argument to `push` must be ARRAY, got %s",
 						args[0].Type())
 				}
 
@@ -139,12 +141,140 @@ var Builtins = []struct {
 				copy(newElements, arr.Elements)
 				newElements[length] = args[1]
 
-				return &Array{Elements: newElements}
+				return &Array{Elements: newElements}, nil
 			},
 		},
 	},
+	{
+		"int",
+		&Builtin{
+			Fn: func(args ...Object) (Object, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return arg, nil
+				case *Float:
+					return &Integer{Value: int64(arg.Value)}, nil
+				case *String:
+					value, err := strconv.ParseInt(arg.Value, 0, 64)
+					if err != nil {
+						return nil, fmt.Errorf("could not parse %q as integer", arg.Value)
+					}
+					return &Integer{Value: value}, nil
+				default:
+					return nil, fmt.Errorf("argument to `int` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"float",
+		&Builtin{
+			Fn: func(args ...Object) (Object, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					return &Float{Value: float64(arg.Value)}, nil
+				case *Float:
+					return arg, nil
+				case *String:
+					value, err := strconv.ParseFloat(arg.Value, 64)
+					if err != nil {
+						return nil, fmt.Errorf("could not parse %q as float", arg.Value)
+					}
+					return &Float{Value: value}, nil
+				default:
+					return nil, fmt.Errorf("argument to `float` not supported, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{
+		"abs",
+		&Builtin{
+			Fn: func(args ...Object) (Object, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
+						len(args))
+				}
+
+				switch arg := args[0].(type) {
+				case *Integer:
+					if arg.Value < 0 {
+						return &Integer{Value: -arg.Value}, nil
+					}
+					return arg, nil
+				case *Float:
+					return &Float{Value: math.Abs(arg.Value)}, nil
+				default:
+					return nil, fmt.Errorf("argument to `abs` must be INTEGER or FLOAT, got %s",
+						args[0].Type())
+				}
+			},
+		},
+	},
+	{"floor", floatFunction("floor", math.Floor)},
+	{"ceil", floatFunction("ceil", math.Ceil)},
+	{"sqrt", floatFunction("sqrt", math.Sqrt)},
+	{"keys", hashFunction("keys", func(pair HashPair) Object { return pair.Key })},
+	{"values", hashFunction("values", func(pair HashPair) Object { return pair.Value })},
+}
+
+// floatFunction wraps a float64 math function as a builtin accepting an
+// INTEGER or FLOAT argument and returning a FLOAT.
+func floatFunction(name string, fn func(float64) float64) *Builtin {
+	return &Builtin{
+		Fn: func(args ...Object) (Object, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: fn(float64(arg.Value))}, nil
+			case *Float:
+				return &Float{Value: fn(arg.Value)}, nil
+			default:
+				return nil, fmt.Errorf("argument to `%s` must be INTEGER or FLOAT, got %s",
+					name, args[0].Type())
+			}
+		},
+	}
 }
 
-func newError(format string, a ...interface{}) *Error {
-	return &Error{Message: fmt.Sprintf(format, a...)}
+// hashFunction returns a builtin that maps the pairs of a HASH argument to an
+// ARRAY with fn, in the insertion order of the hash.
+func hashFunction(name string, fn func(HashPair) Object) *Builtin {
+	return &Builtin{
+		Fn: func(args ...Object) (Object, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+
+			hash, ok := args[0].(*Hash)
+			if !ok {
+				return nil, fmt.Errorf("argument to `%s` must be HASH, got %s",
+					name, args[0].Type())
+			}
+
+			pairs := hash.Pairs()
+			elements := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				elements[i] = fn(pair)
+			}
+			return &Array{Elements: elements}, nil
+		},
+	}
 }
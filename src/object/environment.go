@@ -24,6 +24,19 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Assign rebinds name in the innermost environment that defines it and
+// reports whether such an environment was found.
+func (e *Environment) Assign(name string, val Object) (Object, bool) {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return val, true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return nil, false
+}
+
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
@@ -0,0 +1,126 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Equals reports whether a and b are equal for the == operator: numbers by
+// value, also across integers and floats, strings, booleans and null by
+// value, arrays and hashes element by element. Functions and other objects
+// are only equal to themselves.
+func Equals(a, b Object) bool {
+	return equals(a, b, map[[2]Object]bool{})
+}
+
+// equals compares a and b, seen holds the pairs of containers being compared
+// further up, so that a container holding itself does not recurse forever.
+func equals(a, b Object, seen map[[2]Object]bool) bool {
+	if a == b {
+		return true
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return intEqualsFloat(a.Value, b.Value)
+		}
+	case *Float:
+		switch b := b.(type) {
+		case *Integer:
+			return intEqualsFloat(b.Value, a.Value)
+		case *Float:
+			return a.Value == b.Value
+		}
+	case *String:
+		if b, ok := b.(*String); ok {
+			return a.Value == b.Value
+		}
+	case *Boolean:
+		if b, ok := b.(*Boolean); ok {
+			return a.Value == b.Value
+		}
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		pair := [2]Object{a, b}
+		if seen[pair] {
+			return true
+		}
+		seen[pair] = true
+		for i, el := range a.Elements {
+			if !equals(el, b.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		pair := [2]Object{a, b}
+		if seen[pair] {
+			return true
+		}
+		seen[pair] = true
+		for _, pa := range a.Pairs() {
+			value, ok, _ := b.Get(pa.Key)
+			if !ok || !equals(pa.Value, value, seen) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// intEqualsFloat reports whether i and f are the same number. Unlike
+// float64(i) == f it does not round i, so it agrees with the hash keys.
+func intEqualsFloat(i int64, f float64) bool {
+	n, ok := floatToInt(f)
+	return ok && n == i
+}
+
+// floatToInt returns f as an int64 if it is a whole number in range.
+func floatToInt(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= -math.MinInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// Contains reports whether needle is in container for the in operator: a
+// substring of a string, an element of an array or a key of a hash.
+func Contains(container, needle Object) (bool, error) {
+	switch container := container.(type) {
+	case *String:
+		s, ok := needle.(*String)
+		if !ok {
+			return false, fmt.Errorf("left operand of in must be STRING, got %s", needle.Type())
+		}
+		return strings.Contains(container.Value, s.Value), nil
+	case *Array:
+		for _, el := range container.Elements {
+			if Equals(el, needle) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *Hash:
+		_, ok, err := container.Get(needle)
+		return ok, err
+	default:
+		return false, fmt.Errorf("in operator is not supported: %s", container.Type())
+	}
+}
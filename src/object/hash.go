@@ -0,0 +1,132 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash maps keys to values. Keys are integers, floats, booleans, strings
+// and arrays of those. Pairs are kept in insertion order, and buckets index
+// them by the HashKey of their key. Keys with the same HashKey are told
+// apart with Equals, so that a collision never replaces an unrelated pair
+// and a key is found by any key equal to it, like 1.0 for 1. The zero value
+// is an empty hash.
+type Hash struct {
+	pairs   []HashPair
+	buckets map[HashKey][]int // indexes into pairs
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs:   make([]HashPair, 0, size),
+		buckets: make(map[HashKey][]int, size),
+	}
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string  { return inspect(h, map[Object]bool{}) }
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Get returns the value stored under key. It fails if key cannot be a key.
+func (h *Hash) Get(key Object) (Object, bool, error) {
+	hashKey, err := HashKeyOf(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, i := range h.buckets[hashKey] {
+		if Equals(h.pairs[i].Key, key) {
+			return h.pairs[i].Value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Set stores value under key, replacing the value of an equal key in place.
+// A new key is added after the others. An array key is copied, so changing
+// the array later does not affect the hash.
+func (h *Hash) Set(key, value Object) error {
+	hashKey, err := HashKeyOf(key)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range h.buckets[hashKey] {
+		if Equals(h.pairs[i].Key, key) {
+			h.pairs[i].Value = value
+			return nil
+		}
+	}
+
+	if h.buckets == nil {
+		h.buckets = make(map[HashKey][]int)
+	}
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: copyKey(key), Value: value})
+	return nil
+}
+
+// Pairs returns a copy of the pairs of the hash in insertion order.
+func (h *Hash) Pairs() []HashPair {
+	pairs := make([]HashPair, len(h.pairs))
+	copy(pairs, h.pairs)
+	return pairs
+}
+
+// HashKeyOf returns the HashKey of key, which must be Hashable or an array
+// of keys. An array holding itself cannot be a key.
+func HashKeyOf(key Object) (HashKey, error) {
+	return hashKeyOf(key, map[*Array]bool{})
+}
+
+// hashKeyOf computes the HashKey of key, seen holds the arrays being hashed
+// further up.
+func hashKeyOf(key Object, seen map[*Array]bool) (HashKey, error) {
+	switch key := key.(type) {
+	case Hashable:
+		return key.HashKey(), nil
+	case *Array:
+		if seen[key] {
+			return HashKey{}, fmt.Errorf("unusable as hash key: ARRAY containing itself")
+		}
+		seen[key] = true
+		defer delete(seen, key)
+
+		h := fnv.New64a()
+		var b [8]byte
+		for _, el := range key.Elements {
+			elKey, err := hashKeyOf(el, seen)
+			if err != nil {
+				return HashKey{}, err
+			}
+			h.Write([]byte(elKey.Type))
+			binary.BigEndian.PutUint64(b[:], elKey.Value)
+			h.Write(b[:])
+		}
+		return HashKey{Type: ARRAY_OBJ, Value: h.Sum64()}, nil
+	default:
+		return HashKey{}, fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+}
+
+func copyKey(key Object) Object {
+	array, ok := key.(*Array)
+	if !ok {
+		return key
+	}
+
+	elements := make([]Object, len(array.Elements))
+	for i, el := range array.Elements {
+		elements[i] = copyKey(el)
+	}
+	return &Array{Elements: elements}
+}
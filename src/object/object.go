@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"monkey/ast"
 	"monkey/code"
+	"strconv"
 	"strings"
 )
 
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction implements a builtin. A non-nil error stops the program
+// like a runtime error, a nil Object result stands for null.
+type BuiltinFunction func(args ...Object) (Object, error)
 
 type ObjectType string
 
@@ -18,6 +22,7 @@ const (
 	ERROR_OBJ = "ERROR"
 
 	INTEGER_OBJ = "INTEGER"
+	FLOAT_OBJ   = "FLOAT"
 	BOOLEAN_OBJ = "BOOLEAN"
 	STRING_OBJ  = "STRING"
 
@@ -31,11 +36,16 @@ const (
 
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
 	CLOSURE_OBJ           = "CLOSURE"
+	CELL_OBJ              = "CELL"
+
+	ITERATOR_OBJ = "ITERATOR"
+	BREAK_OBJ    = "BREAK"
+	CONTINUE_OBJ = "CONTINUE"
 )
 
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object
+	Free []*Cell
 }
 
 func (c *Closure) Type() ObjectType {
@@ -46,10 +56,22 @@ func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
 
+// Cell holds a variable captured by a closure. The closure and the function
+// defining the variable share the cell, so assignments are seen by both.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return c.Value.Inspect() }
+
 type CompiledFunction struct {
+	Name          string // the name the function was bound to with let, if any
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	LineTable     code.LineTable    // maps Instructions offsets to source positions
+	Handlers      code.HandlerTable // the try statements of the function
 }
 
 func (c *CompiledFunction) Type() ObjectType {
@@ -84,6 +106,30 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a float as such, e.g. 2.0 rather than 2.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// HashKey gives a whole number the key of the equal integer, so that 1 and
+// 1.0 are the same hash key as they are equal.
+func (f *Float) HashKey() HashKey {
+	if i, ok := floatToInt(f.Value); ok {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(i)}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -114,8 +160,20 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue signal a loop control statement while evaluating.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Error struct {
 	Message string
+	Value   Object // the thrown value for a throw statement, nil otherwise
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
@@ -153,10 +211,15 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: stringHash(s.Value)}
+}
 
-	return HashKey{Type: s.Type(), Value: h.Sum64()}
+// stringHash hashes the strings used as hash keys, tests replace it to
+// provoke collisions.
+var stringHash = func(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
 }
 
 type Builtin struct {
@@ -171,43 +234,93 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
+func (ao *Array) Inspect() string  { return inspect(ao, map[Object]bool{}) }
+
+// inspect formats obj like Inspect, seen holds the arrays and hashes being
+// formatted further up, so that a container holding itself prints as [...]
+// or {...} instead of recursing forever.
+func inspect(obj Object, seen map[Object]bool) string {
 	var out bytes.Buffer
 
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] {
+			return "[...]"
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		elements := []string{}
+		for _, e := range obj.Elements {
+			elements = append(elements, inspect(e, seen))
+		}
+
+		out.WriteString("[")
+		out.WriteString(strings.Join(elements, ", "))
+		out.WriteString("]")
+	case *Hash:
+		if seen[obj] {
+			return "{...}"
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+
+		pairs := []string{}
+		for _, pair := range obj.pairs {
+			pairs = append(pairs, fmt.Sprintf("%s: %s",
+				inspect(pair.Key, seen), inspect(pair.Value, seen)))
+		}
+
+		out.WriteString("{")
+		out.WriteString(strings.Join(pairs, ", "))
+		out.WriteString("}")
+	default:
+		return obj.Inspect()
 	}
 
-	out.WriteString("[")
-	out.WriteString(strings.Join(elements, ", "))
-	out.WriteString("]")
-
 	return out.String()
 }
 
-type HashPair struct {
-	Key   Object
-	Value Object
+// Iterator steps through the elements of an array, the characters of a
+// string or the keys of a hash in insertion order, for for-in loops.
+type Iterator struct {
+	elements []Object
+	index    int
 }
 
-type Hash struct {
-	Pairs map[HashKey]HashPair
+// NewIterator returns an iterator over obj, or false if obj is not iterable.
+func NewIterator(obj Object) (*Iterator, bool) {
+	var elements []Object
+
+	switch obj := obj.(type) {
+	case *Array:
+		elements = make([]Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+	case *String:
+		for _, r := range obj.Value {
+			elements = append(elements, &String{Value: string(r)})
+		}
+	case *Hash:
+		for _, pair := range obj.Pairs() {
+			elements = append(elements, pair.Key)
+		}
+	default:
+		return nil, false
+	}
+
+	return &Iterator{elements: elements}, true
 }
 
-func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
-	var out bytes.Buffer
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return fmt.Sprintf("Iterator[%p]", it) }
 
-	pairs := []string{}
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+// Next returns the next element, or false once the iterator is exhausted.
+func (it *Iterator) Next() (Object, bool) {
+	if it.index >= len(it.elements) {
+		return nil, false
 	}
 
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-
-	return out.String()
+	el := it.elements[it.index]
+	it.index++
+	return el, true
 }
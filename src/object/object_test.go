@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +61,230 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{2.5, "2.5"},
+		{-0.125, "-0.125"},
+		{1e21, "1e+21"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect for %g. want=%q, got=%q", tt.value, tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestEquals(t *testing.T) {
+	str := func(s string) Object { return &String{Value: s} }
+	arr := func(els ...Object) *Array { return &Array{Elements: els} }
+	hash := func(kv ...Object) *Hash {
+		h := NewHash(len(kv) / 2)
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	builtin := &Builtin{}
+
+	selfA, selfB := arr(one), arr(one)
+	selfA.Elements = append(selfA.Elements, selfA)
+	selfB.Elements = append(selfB.Elements, selfB)
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{one, &Integer{Value: 1}, true},
+		{one, two, false},
+		{one, &Float{Value: 1}, true},
+		{&Float{Value: 2.5}, &Float{Value: 2.5}, true},
+		{str("a"), str("a"), true},
+		{str("a"), str("b"), false},
+		{str("1"), one, false},
+		{&Boolean{Value: true}, &Boolean{Value: true}, true},
+		{&Null{}, &Null{}, true},
+		{&Null{}, &Boolean{Value: false}, false},
+		{arr(one, str("a")), arr(one, str("a")), true},
+		{arr(one, arr(two)), arr(one, arr(two)), true},
+		{arr(one), arr(one, two), false},
+		{arr(one, two), arr(two, one), false},
+		{hash(str("a"), one), hash(str("a"), one), true},
+		{hash(str("a"), arr(one)), hash(str("a"), arr(one)), true},
+		{hash(str("a"), one), hash(str("a"), two), false},
+		{hash(str("a"), one), hash(str("b"), one), false},
+		{hash(), arr(), false},
+		{builtin, builtin, true},
+		{builtin, &Builtin{}, false},
+		{selfA, selfB, true},
+	}
+
+	for i, tt := range tests {
+		if got := Equals(tt.a, tt.b); got != tt.expected {
+			t.Errorf("tests[%d]: Equals(%s, %s) = %t, want %t", i, tt.a.Type(), tt.b.Type(), got, tt.expected)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	str := func(s string) Object { return &String{Value: s} }
+	one := &Integer{Value: 1}
+	array := &Array{Elements: []Object{one, &Array{Elements: []Object{str("a")}}}}
+	hash := NewHash(1)
+	hash.Set(one, str("one"))
+
+	tests := []struct {
+		container, needle Object
+		expected          bool
+	}{
+		{str("cat"), str("at"), true},
+		{str("cat"), str("dog"), false},
+		{array, &Float{Value: 1}, true},
+		{array, &Array{Elements: []Object{str("a")}}, true},
+		{array, str("a"), false},
+		{hash, &Integer{Value: 1}, true},
+		{hash, str("one"), false},
+	}
+
+	for i, tt := range tests {
+		got, err := Contains(tt.container, tt.needle)
+		if err != nil {
+			t.Errorf("tests[%d]: unexpected error: %s", i, err)
+		} else if got != tt.expected {
+			t.Errorf("tests[%d]: Contains = %t, want %t", i, got, tt.expected)
+		}
+	}
+
+	if _, err := Contains(one, one); err == nil || err.Error() != "in operator is not supported: INTEGER" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestContainsAgreesForArraysAndHashes(t *testing.T) {
+	keys := []Object{
+		&Integer{Value: 1},
+		&Float{Value: 2.5},
+		&String{Value: "a"},
+		&Boolean{Value: true},
+		&Array{Elements: []Object{&Integer{Value: 3}}},
+		&Integer{Value: 1 << 53},
+	}
+	array := &Array{Elements: keys}
+	hash := NewHash(len(keys))
+	for _, key := range keys {
+		hash.Set(key, &Null{})
+	}
+
+	needles := []Object{
+		&Integer{Value: 1},
+		&Float{Value: 1},
+		&Float{Value: -0.0},
+		&Integer{Value: 2},
+		&Float{Value: 2.5},
+		&String{Value: "1"},
+		&Boolean{Value: true},
+		&Integer{Value: 0},
+		&Array{Elements: []Object{&Float{Value: 3}}},
+		&Array{Elements: []Object{&Float{Value: 3.5}}},
+		&Float{Value: 1 << 53},
+		&Integer{Value: 1<<53 + 1},
+		&Float{Value: math.NaN()},
+		&Float{Value: math.Inf(1)},
+	}
+
+	for _, needle := range needles {
+		inArray, err := Contains(array, needle)
+		if err != nil {
+			t.Fatalf("Contains(array, %s) failed: %s", needle.Inspect(), err)
+		}
+		inHash, err := Contains(hash, needle)
+		if err != nil {
+			t.Fatalf("Contains(hash, %s) failed: %s", needle.Inspect(), err)
+		}
+		if inArray != inHash {
+			t.Errorf("%s: in array=%t, in hash=%t", needle.Inspect(), inArray, inHash)
+		}
+	}
+
+	mixed := NewHash(2)
+	mixed.Set(&Integer{Value: 1}, &String{Value: "i"})
+	mixed.Set(&Float{Value: 1}, &String{Value: "f"})
+	if mixed.Inspect() != "{1: f}" {
+		t.Errorf("1 and 1.0 should be one key. got=%s", mixed.Inspect())
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	defer func(h func(string) uint64) { stringHash = h }(stringHash)
+	stringHash = func(string) uint64 { return 42 }
+
+	a, b := &String{Value: "a"}, &String{Value: "b"}
+	if a.HashKey() != b.HashKey() {
+		t.Fatalf("keys should collide")
+	}
+
+	h := NewHash(0)
+	h.Set(a, &Integer{Value: 1})
+	h.Set(b, &Integer{Value: 2})
+	h.Set(&String{Value: "a"}, &Integer{Value: 3})
+
+	if h.Len() != 2 {
+		t.Fatalf("wrong length. want=2, got=%d", h.Len())
+	}
+	for key, expected := range map[string]int64{"a": 3, "b": 2} {
+		value, ok, err := h.Get(&String{Value: key})
+		if err != nil || !ok {
+			t.Fatalf("no value for %q: %v", key, err)
+		}
+		if value.(*Integer).Value != expected {
+			t.Errorf("wrong value for %q. want=%d, got=%s", key, expected, value.Inspect())
+		}
+	}
+	if _, ok, _ := h.Get(&String{Value: "c"}); ok {
+		t.Errorf("colliding missing key should not be found")
+	}
+}
+
+func TestHashArrayKeys(t *testing.T) {
+	one := &Integer{Value: 1}
+	key := &Array{Elements: []Object{one, &String{Value: "a"}}}
+
+	h := NewHash(0)
+	if err := h.Set(key, one); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	key.Elements[0] = &Integer{Value: 2}
+
+	tests := []struct {
+		key      Object
+		expected bool
+	}{
+		{&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}, true},
+		{key, false},
+		{&Array{Elements: []Object{&Float{Value: 1}, &String{Value: "a"}}}, true},
+		{&Array{Elements: []Object{&Float{Value: 1.5}, &String{Value: "a"}}}, false},
+		{&Array{Elements: []Object{one}}, false},
+	}
+
+	for _, tt := range tests {
+		_, ok, err := h.Get(tt.key)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %s", tt.key.Inspect(), err)
+		}
+		if ok != tt.expected {
+			t.Errorf("Get(%s) found=%t, want=%t", tt.key.Inspect(), ok, tt.expected)
+		}
+	}
+
+	bad := &Array{Elements: []Object{&Hash{}}}
+	if err := h.Set(bad, one); err == nil || err.Error() != "unusable as hash key: HASH" {
+		t.Errorf("wrong error for %s: %v", bad.Inspect(), err)
+	}
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/token"
+	"strings"
+)
+
+// ParseError describes a single syntax error found by the parser.
+type ParseError struct {
+	Pos      token.Position
+	Expected []token.TokenType // token types that would have been accepted, if known
+	Found    token.Token
+	Message  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func (p *Parser) addError(found token.Token, expected []token.TokenType, format string, a ...interface{}) {
+	p.errors = append(p.errors, &ParseError{
+		Pos:      found.Pos,
+		Expected: expected,
+		Found:    found,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (p *Parser) peekError(t ...token.TokenType) {
+	expected := make([]string, len(t))
+	for i, tt := range t {
+		expected[i] = string(tt)
+	}
+
+	p.addError(p.peekToken, t, "expected next token to be %s, got %s instead",
+		strings.Join(expected, " or "), p.peekToken.Type)
+}
+
+func (p *Parser) noPrefixParseFnError(t token.TokenType) {
+	p.addError(p.curToken, nil, "no prefix parse function for %s found", t)
+}
+
+// synchronize skips tokens until the end of the current statement, so that
+// parsing can resume after a syntax error. It stops on a ';' or '}' (which is
+// left as the current token) or on EOF.
+func (p *Parser) synchronize() {
+	for !p.curTokenIs(token.SEMICOLON) &&
+		!p.curTokenIs(token.RBRACE) &&
+		!p.curTokenIs(token.EOF) {
+		p.nextToken()
+	}
+}
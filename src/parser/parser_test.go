@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
+	"reflect"
 	"testing"
 )
 
@@ -139,6 +141,37 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	input := "2.5e1;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program has not enough statements. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	literal, ok := stmt.Expression.(*ast.FloatLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != 25 {
+		t.Errorf("literal.Value not %f. got=%f", 25.0, literal.Value)
+	}
+	if literal.TokenLiteral() != "2.5e1" {
+		t.Errorf("literal.TokenLiteral not %s. got=%s", "2.5e1",
+			literal.TokenLiteral())
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -199,6 +232,11 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"true && false;", true, "&&", false},
+		{"true || false;", true, "||", false},
 		{"foobar + barfoo;", "foobar", "+", "barfoo"},
 		{"foobar - barfoo;", "foobar", "-", "barfoo"},
 		{"foobar * barfoo;", "foobar", "*", "barfoo"},
@@ -305,6 +343,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"3 < 5 == true",
 			"((3 < 5) == true)",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"x = a || b",
+			"x = (a || b)",
+		},
 		{
 			"1 + (2 + 3) + 4",
 			"((1 + (2 + 3)) + 4)",
@@ -349,6 +407,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"a + 1 in b == !c",
+			"(((a + 1) in b) == (!c))",
+		},
+		{
+			"a in b && c in d",
+			"((a in b) && (c in d))",
+		},
 	}
 
 	for _, tt := range tests {
@@ -509,6 +575,182 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x; break; continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body is not 3 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[1] is not ast.BreakStatement. got=%T", stmt.Body.Statements[1])
+	}
+
+	if _, ok := stmt.Body.Statements[2].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[2] is not ast.ContinueStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	input := `for (x in [1, 2]) { x; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForInStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "x") {
+		return
+	}
+
+	array, ok := stmt.Iterable.(*ast.ArrayLiteral)
+	if !ok || len(array.Elements) != 2 {
+		t.Fatalf("stmt.Iterable is not an ast.ArrayLiteral of 2. got=%T", stmt.Iterable)
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statements. got=%d\n", len(stmt.Body.Statements))
+	}
+
+	if stmt.String() != "for (x in [1, 2]) x" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	input := `throw "oops" + x;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ThrowStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ThrowStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if stmt.String() != "throw (oops + x);" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input      string
+		catchParam string
+		hasFinally bool
+		expected   string
+	}{
+		{`try { x; } catch (e) { e; }`, "e", false, "try x catch (e) e"},
+		{`try { x; } finally { y; }`, "", true, "try x finally y"},
+		{`try { x; } catch (err) { err; } finally { y; };`, "err", true,
+			"try x catch (err) err finally y"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.TryStatement. got=%T",
+				program.Statements[0])
+		}
+
+		if len(stmt.Block.Statements) != 1 {
+			t.Errorf("try block is not 1 statement. got=%d", len(stmt.Block.Statements))
+		}
+
+		if tt.catchParam == "" {
+			if stmt.Catch != nil || stmt.CatchParam != nil {
+				t.Errorf("stmt.Catch should be nil. got=%v", stmt.Catch)
+			}
+		} else if stmt.Catch == nil || !testIdentifier(t, stmt.CatchParam, tt.catchParam) {
+			t.Errorf("wrong catch clause for %q", tt.input)
+		}
+
+		if (stmt.Finally != nil) != tt.hasFinally {
+			t.Errorf("wrong finally clause for %q. got=%v", tt.input, stmt.Finally)
+		}
+
+		if stmt.String() != tt.expected {
+			t.Errorf("stmt.String() wrong. want=%q, got=%q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestTryStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { x; } y;`, "1:12: expected next token to be CATCH or FINALLY, got IDENT instead"},
+		{`try { x; } catch { y; }`, "1:18: expected next token to be (, got { instead"},
+		{`try { x; } catch (1) { y; }`, "1:19: expected next token to be IDENT, got INT instead"},
+		{`throw;`, "1:6: no prefix parse function for ; found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected a parser error", tt.input)
+			continue
+		}
+		if errors[0].Error() != tt.expected {
+			t.Errorf("%q: wrong message. want=%q, got=%q", tt.input, tt.expected, errors[0].Error())
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 
@@ -556,6 +798,37 @@ func TestFunctionLiteralParsing(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T",
+			stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q\n",
+			function.Name)
+	}
+}
+
 func TestFunctionParameterParsing(t *testing.T) {
 	tests := []struct {
 		input          string
@@ -815,6 +1088,10 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		expectedValue := expected[literal.String()]
 		testIntegerLiteral(t, value, expectedValue)
 	}
+
+	if hash.String() != "{one:1, two:2, three:3}" {
+		t.Errorf("keys are not in source order. got=%s", hash.String())
+	}
 }
 
 func TestParsingHashLiteralsBooleanKeys(t *testing.T) {
@@ -1076,8 +1353,169 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 
 	t.Errorf("parser has %d errors", len(errors))
-	for _, msg := range errors {
-		t.Errorf("parser error: %q", msg)
+	for _, err := range errors {
+		t.Errorf("parser error: %q", err.Error())
 	}
 	t.FailNow()
 }
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5;", "x = 5"},
+		{"x += y * 2;", "x += (y * 2)"},
+		{"a[1] -= 3;", "(a[1]) -= 3"},
+		{"x = y = 1;", "x = y = 1"},
+		{"x == y;", "(x == y)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	// assignment is right-associative
+	l := lexer.New("x = y = 1;")
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	outer, ok := stmt.Expression.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.AssignExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, outer.Target, "x") {
+		return
+	}
+	inner, ok := outer.Value.(*ast.AssignExpression)
+	if !ok {
+		t.Fatalf("outer.Value is not ast.AssignExpression. got=%T", outer.Value)
+	}
+	if !testIdentifier(t, inner.Target, "y") {
+		return
+	}
+	testIntegerLiteral(t, inner.Value, 1)
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	l := lexer.New("1 = 2;")
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%d", len(errors))
+	}
+	if errors[0].Error() != "1:3: cannot assign to 1" {
+		t.Errorf("wrong message. got=%q", errors[0].Error())
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	input := `let x = 1;
+let = 2;
+let y = fn(a) { let 3; a };
+x + ;
+let w = [1, 2;
+let z = 3;
+z;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	tests := []struct {
+		expectedPos      string
+		expectedExpected []token.TokenType
+		expectedFound    token.TokenType
+		expectedError    string
+	}{
+		{"2:5", []token.TokenType{token.IDENT}, token.ASSIGN,
+			"2:5: expected next token to be IDENT, got = instead"},
+		{"3:21", []token.TokenType{token.IDENT}, token.INT,
+			"3:21: expected next token to be IDENT, got INT instead"},
+		{"4:5", nil, token.SEMICOLON,
+			"4:5: no prefix parse function for ; found"},
+		{"5:14", []token.TokenType{token.COMMA, token.RBRACKET}, token.SEMICOLON,
+			"5:14: expected next token to be , or ], got ; instead"},
+	}
+
+	errors := p.Errors()
+	if len(errors) != len(tests) {
+		for _, err := range errors {
+			t.Errorf("parser error: %q", err.Error())
+		}
+		t.Fatalf("wrong number of errors. want=%d, got=%d", len(tests), len(errors))
+	}
+
+	for i, tt := range tests {
+		err := errors[i]
+		if err.Pos.String() != tt.expectedPos {
+			t.Errorf("errors[%d] - wrong position. want=%q, got=%q", i, tt.expectedPos, err.Pos)
+		}
+		if !reflect.DeepEqual(err.Expected, tt.expectedExpected) {
+			t.Errorf("errors[%d] - wrong expected tokens. want=%v, got=%v",
+				i, tt.expectedExpected, err.Expected)
+		}
+		if err.Found.Type != tt.expectedFound {
+			t.Errorf("errors[%d] - wrong found token. want=%q, got=%q",
+				i, tt.expectedFound, err.Found.Type)
+		}
+		if err.Error() != tt.expectedError {
+			t.Errorf("errors[%d] - wrong message. want=%q, got=%q", i, tt.expectedError, err.Error())
+		}
+	}
+
+	// the statements without errors are kept
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d",
+			len(program.Statements))
+	}
+	if !testLetStatement(t, program.Statements[0], "x") {
+		return
+	}
+	if !testLetStatement(t, program.Statements[1], "z") {
+		return
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+		numErrors   int
+	}{
+		{"let x = 1;\nx * 2 /* never closed\nlet y = 2;", "2:7", 1},
+		{"let x /* never closed", "1:7", 2},
+		{"[1, 2 /* never closed", "1:7", 2},
+		{"foo(/* never closed", "1:5", 3},
+		{"/* never closed", "1:1", 1},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != tt.numErrors {
+			t.Errorf("%q: wrong number of errors. want=%d, got=%d (%v)",
+				tt.input, tt.numErrors, len(errors), errors)
+			continue
+		}
+		expected := tt.expectedPos + ": unterminated block comment"
+		if errors[0].Error() != expected || errors[0].Found.Type != token.COMMENT {
+			t.Errorf("%q: wrong error. want=%q, got=%q (found %s)",
+				tt.input, expected, errors[0].Error(), errors[0].Found.Type)
+		}
+	}
+}
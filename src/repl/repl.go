@@ -2,6 +2,7 @@ package repl
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"monkey/compiler"
@@ -22,8 +23,7 @@ func Start(in io.Reader, out io.Writer) {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
 
-	// FIXME 使用动态方式，而非一开始就分配大块内存
-	globals := make([]object.Object, vm.GlobalSize)
+	var globals []object.Object
 
 	for {
 		fmt.Print(PROMPT)
@@ -52,14 +52,15 @@ func Start(in io.Reader, out io.Writer) {
 		code := comp.Bytecode()
 		constants = code.Constants
 
-		vm := vm.NewWithGlobalsStore(code, globals)
-		err = vm.Run()
+		machine := vm.NewWithGlobalsStore(code, globals)
+		err = machine.Run()
+		globals = machine.Globals()
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			printRuntimeError(out, err)
 			continue
 		}
 
-		stackTop := vm.LastPoppedStackElem()
+		stackTop := machine.LastPoppedStackElem()
 		io.WriteString(out, stackTop.Inspect())
 		io.WriteString(out, "\n")
 	}
@@ -78,11 +79,22 @@ const MONKEY_FACE = `            __,__
            '-----'
 `
 
-func printParserErrors(out io.Writer, errors []string) {
+func printParserErrors(out io.Writer, errors []*parser.ParseError) {
 	io.WriteString(out, MONKEY_FACE)
 	io.WriteString(out, "Woops! We ran into some monkey business here!\n")
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
 	}
 }
+
+func printRuntimeError(out io.Writer, err error) {
+	io.WriteString(out, "Woops! Executing bytecode failed:\n")
+
+	var runtimeErr *vm.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		io.WriteString(out, runtimeErr.Traceback())
+		return
+	}
+	fmt.Fprintf(out, " %s\n", err)
+}
@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"monkey/object"
+	"monkey/token"
+)
+
+// ErrBudgetExceeded is wrapped by the errors returned when a script exceeds
+// one of the Limits set with SetLimits.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// ErrMemoryExceeded is wrapped by the errors returned when a script
+// allocates more than Limits.MaxMemory bytes.
+var ErrMemoryExceeded = errors.New("memory limit exceeded")
+
+// ErrCanceled is wrapped by the errors returned when the context passed to
+// RunContext or CallContext is done. The context's error is wrapped too, so
+// errors.Is(err, context.DeadlineExceeded) tells a timeout apart.
+var ErrCanceled = errors.New("execution canceled")
+
+type canceledError struct {
+	err error // context.Canceled or context.DeadlineExceeded
+}
+
+func (e *canceledError) Error() string        { return fmt.Sprintf("%s: %s", ErrCanceled, e.err) }
+func (e *canceledError) Unwrap() error        { return e.err }
+func (e *canceledError) Is(target error) bool { return target == ErrCanceled }
+
+// Exception is the error raised by a throw statement, Value is the thrown
+// value. A RuntimeError wraps it when no try statement catches it.
+type Exception struct {
+	Value object.Object
+}
+
+func (e *Exception) Error() string { return "uncaught exception: " + e.Value.Inspect() }
+
+// StackFrame describes one active call at the moment a runtime error occurred.
+type StackFrame struct {
+	Function string // name of the function, "<main>" for the top level
+	IP       int    // offset of the executing instruction
+	Pos      token.Position
+}
+
+func (sf StackFrame) String() string {
+	if sf.Pos.IsValid() {
+		return fmt.Sprintf("%s (%s)", sf.Function, sf.Pos)
+	}
+	return fmt.Sprintf("%s (ip=%d)", sf.Function, sf.IP)
+}
+
+// RuntimeError is returned by Run when execution fails. It wraps the
+// underlying error and keeps the call stack, innermost frame first.
+type RuntimeError struct {
+	Err    error
+	Frames []StackFrame
+}
+
+func (e *RuntimeError) Error() string { return e.Err.Error() }
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// Pos returns the source position of the failing instruction, if known.
+func (e *RuntimeError) Pos() token.Position {
+	if len(e.Frames) == 0 {
+		return token.Position{}
+	}
+	return e.Frames[0].Pos
+}
+
+// Traceback renders the error followed by the Monkey call stack.
+func (e *RuntimeError) Traceback() string {
+	var out bytes.Buffer
+
+	out.WriteString("runtime error: ")
+	out.WriteString(e.Err.Error())
+	out.WriteString("\n")
+	for _, f := range e.Frames {
+		out.WriteString("    at ")
+		out.WriteString(f.String())
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
+
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	frames := make([]StackFrame, 0, vm.frameIndex)
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		frames = append(frames, StackFrame{
+			Function: f.functionName(),
+			IP:       f.ip,
+			Pos:      f.Pos(),
+		})
+	}
+	return &RuntimeError{Err: err, Frames: frames}
+}
@@ -0,0 +1,44 @@
+package vm
+
+import (
+	"fmt"
+	"monkey/object"
+)
+
+// Approximate sizes in bytes used for memory accounting. They only need to
+// grow with what a script allocates, not to match the Go runtime exactly.
+const (
+	objectSize   = 16 // header of a small object, e.g. a string or a cell
+	elementSize  = 16 // an object.Object interface value in an array
+	hashPairSize = 64 // a map entry: HashKey plus HashPair
+)
+
+func stringSize(s string) int64  { return objectSize + int64(len(s)) }
+func arraySize(n int) int64      { return objectSize + int64(n)*elementSize }
+func hashSize(n int) int64       { return objectSize + int64(n)*hashPairSize }
+func closureSize(free int) int64 { return objectSize + int64(free)*(8+objectSize) }
+
+// sizeOf estimates the bytes allocated for a value returned by a builtin,
+// counting the value itself but not the objects it refers to.
+func sizeOf(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.String:
+		return stringSize(obj.Value)
+	case *object.Array:
+		return arraySize(len(obj.Elements))
+	case *object.Hash:
+		return hashSize(obj.Len())
+	default:
+		return objectSize
+	}
+}
+
+// allocate counts size bytes against the memory limit. It is called before
+// the allocation, so an oversized string or array is never built.
+func (vm *VM) allocate(size int64) error {
+	vm.allocated += size
+	if max := vm.limits.MaxMemory; max > 0 && vm.allocated > max {
+		return fmt.Errorf("%w: more than %d bytes allocated", ErrMemoryExceeded, max)
+	}
+	return nil
+}
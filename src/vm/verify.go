@@ -0,0 +1,325 @@
+package vm
+
+import (
+	"fmt"
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+)
+
+// Verify checks that bytecode is safe to run before handing it to the VM,
+// which trusts its input completely. It walks the main program and every
+// CompiledFunction in the constant pool and checks that
+//
+//   - every opcode is known and its operands are complete,
+//   - constant, global, local, builtin and free variable indexes are in range,
+//   - jumps land on an instruction boundary,
+//   - the stack depth is the same on every path to an instruction, never
+//     drops below zero and functions can only be left with a return,
+//   - handlers cover whole instructions and the instructions they protect
+//     leave the values below the depth of the handler alone.
+//
+// The compiler only produces valid bytecode, Verify is meant for bytecode
+// read from files.
+func Verify(bc *compiler.Bytecode) error {
+	v := &verifier{constants: bc.Constants, numFree: map[int]int{}}
+
+	// the number of free variables of a function is only known at the
+	// OpClosure instructions creating it, collect those first
+	err := v.collectClosures("<main>", bc.Instructions)
+	if err != nil {
+		return err
+	}
+	for i, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			err := v.collectClosures(functionName(i, fn), fn.Instructions)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = v.verifyFunction("<main>", bc.Instructions, bc.Handlers, 0, 0, true)
+	if err != nil {
+		return err
+	}
+	for i, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if fn.NumParameters > fn.NumLocals {
+				return fmt.Errorf("invalid bytecode in %s: %d parameters but only %d locals",
+					functionName(i, fn), fn.NumParameters, fn.NumLocals)
+			}
+			err := v.verifyFunction(functionName(i, fn), fn.Instructions, fn.Handlers, fn.NumLocals, v.numFree[i], false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func functionName(constIndex int, fn *object.CompiledFunction) string {
+	if fn.Name != "" {
+		return fmt.Sprintf("function %s (constant %d)", fn.Name, constIndex)
+	}
+	return fmt.Sprintf("function constant %d", constIndex)
+}
+
+type verifier struct {
+	constants []object.Object
+	numFree   map[int]int // constant index of a function -> its free variable count
+}
+
+// instruction is a decoded instruction at offset in a function.
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	next     int // offset of the following instruction
+}
+
+func decode(ins code.Instructions) ([]instruction, error) {
+	var decoded []instruction
+	for offset := 0; offset < len(ins); {
+		def, err := code.Lookup(ins[offset])
+		if err != nil {
+			return nil, fmt.Errorf("%04d: %s", offset, err)
+		}
+
+		// a wide instruction is decoded as one, at the offset of its OpWide
+		opOffset := offset
+		if code.Opcode(ins[offset]) == code.OpWide {
+			opOffset++
+			if opOffset == len(ins) {
+				return nil, fmt.Errorf("%04d: OpWide at the end of the instructions", offset)
+			}
+			def, err = code.LookupWide(ins[opOffset])
+			if err != nil {
+				return nil, fmt.Errorf("%04d: %s", offset, err)
+			}
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+		if opOffset+1+width > len(ins) {
+			return nil, fmt.Errorf("%04d: %s: truncated operands", offset, def.Name)
+		}
+
+		operands, read := code.ReadOperands(def, ins[opOffset+1:])
+		decoded = append(decoded, instruction{
+			offset:   offset,
+			op:       code.Opcode(ins[opOffset]),
+			def:      def,
+			operands: operands,
+			next:     opOffset + 1 + read,
+		})
+		offset = opOffset + 1 + read
+	}
+	return decoded, nil
+}
+
+func (v *verifier) collectClosures(name string, ins code.Instructions) error {
+	decoded, err := decode(ins)
+	if err != nil {
+		return fmt.Errorf("invalid bytecode in %s: %s", name, err)
+	}
+
+	for _, in := range decoded {
+		if in.op != code.OpClosure {
+			continue
+		}
+		constIndex, numFree := in.operands[0], in.operands[1]
+		if constIndex >= len(v.constants) {
+			return fmt.Errorf("invalid bytecode in %s: %04d: constant index %d out of range",
+				name, in.offset, constIndex)
+		}
+		if _, ok := v.constants[constIndex].(*object.CompiledFunction); !ok {
+			return fmt.Errorf("invalid bytecode in %s: %04d: OpClosure of a %s constant",
+				name, in.offset, v.constants[constIndex].Type())
+		}
+		if n, ok := v.numFree[constIndex]; ok && n != numFree {
+			return fmt.Errorf("invalid bytecode in %s: %04d: function constant %d closed over %d and %d free variables",
+				name, in.offset, constIndex, n, numFree)
+		}
+		v.numFree[constIndex] = numFree
+	}
+	return nil
+}
+
+func (v *verifier) verifyFunction(
+	name string,
+	ins code.Instructions,
+	handlers code.HandlerTable,
+	numLocals, numFree int,
+	main bool,
+) error {
+	decoded, err := decode(ins)
+	if err != nil {
+		return fmt.Errorf("invalid bytecode in %s: %s", name, err)
+	}
+
+	index := make(map[int]int, len(decoded)) // offset -> position in decoded
+	for i, in := range decoded {
+		index[in.offset] = i
+	}
+
+	fail := func(in instruction, format string, a ...interface{}) error {
+		return fmt.Errorf("invalid bytecode in %s: %04d %s: %s",
+			name, in.offset, in.def.Name, fmt.Sprintf(format, a...))
+	}
+
+	for _, h := range handlers {
+		_, startOk := index[h.Start]
+		_, endOk := index[h.End]
+		_, targetOk := index[h.Target]
+		if !startOk || !(endOk || h.End == len(ins)) || h.Start >= h.End || !targetOk || h.Depth < 0 {
+			return fmt.Errorf("invalid bytecode in %s: invalid handler %+v", name, h)
+		}
+	}
+
+	// depths[i] is the stack depth before decoded[i], -1 until it is reached
+	depths := make([]int, len(decoded))
+	for i := range depths {
+		depths[i] = -1
+	}
+	maxDepth := 0
+
+	var work []int
+	// flow records that the instruction at offset is reached with depth,
+	// reaching the end is only allowed in the main program
+	flow := func(from instruction, offset, depth int) error {
+		if offset == len(ins) {
+			if !main {
+				return fail(from, "control reaches the end of the function without a return")
+			}
+			return nil
+		}
+		i, ok := index[offset]
+		if !ok {
+			return fail(from, "jump target %d is not an instruction boundary", offset)
+		}
+		if depths[i] == -1 {
+			depths[i] = depth
+			work = append(work, i)
+			return nil
+		}
+		if depths[i] != depth {
+			return fail(decoded[i], "stack depth %d on one path and %d on another", depths[i], depth)
+		}
+		return nil
+	}
+
+	if len(decoded) == 0 {
+		if !main {
+			return fmt.Errorf("invalid bytecode in %s: empty function", name)
+		}
+		return nil
+	}
+	depths[0] = 0
+	work = append(work, 0)
+
+	for len(work) > 0 {
+		in := decoded[work[len(work)-1]]
+		work = work[:len(work)-1]
+		depth := depths[index[in.offset]]
+
+		err := v.checkOperands(in, numLocals, numFree, main, fail)
+		if err != nil {
+			return err
+		}
+
+		pops, pushes := code.StackEffect(in.op, in.operands)
+		if depth < pops {
+			return fail(in, "needs %d values on the stack, has %d", pops, depth)
+		}
+		after := depth - pops + pushes
+		if after > maxDepth {
+			maxDepth = after
+		}
+
+		// a failing instruction continues at its handler with the stack cut
+		// back to the handler's depth and the caught value on top
+		if h, ok := handlers.Find(in.offset); ok {
+			if depth-pops < h.Depth {
+				return fail(in, "stack depth %d is below the depth %d of its handler", depth-pops, h.Depth)
+			}
+			err := flow(in, h.Target, h.Depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch in.op {
+		case code.OpReturnValue, code.OpReturn, code.OpThrow:
+			// leaves the function
+		case code.OpJump:
+			err = flow(in, in.operands[0], after)
+		case code.OpJumpNotTruthy:
+			err = flow(in, in.operands[0], after)
+			if err == nil {
+				err = flow(in, in.next, after)
+			}
+		case code.OpIterNext:
+			// the element is only pushed when the iterator is not exhausted
+			err = flow(in, in.operands[0], after-1)
+			if err == nil {
+				err = flow(in, in.next, after)
+			}
+		default:
+			err = flow(in, in.next, after)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if numLocals+maxDepth > DefaultMaxStackSize {
+		return fmt.Errorf("invalid bytecode in %s: needs %d stack slots, the stack has %d",
+			name, numLocals+maxDepth, DefaultMaxStackSize)
+	}
+
+	return nil
+}
+
+func (v *verifier) checkOperands(
+	in instruction,
+	numLocals, numFree int,
+	main bool,
+	fail func(instruction, string, ...interface{}) error,
+) error {
+	switch in.op {
+	case code.OpConstant:
+		if in.operands[0] >= len(v.constants) {
+			return fail(in, "constant index %d out of range", in.operands[0])
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if in.operands[0] >= GlobalSize {
+			return fail(in, "global index %d out of range", in.operands[0])
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpCaptureLocal:
+		if in.operands[0] >= numLocals {
+			return fail(in, "local index %d out of range, the function has %d locals", in.operands[0], numLocals)
+		}
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		if in.operands[0] >= numFree {
+			return fail(in, "free variable index %d out of range, the function has %d free variables", in.operands[0], numFree)
+		}
+	case code.OpHash:
+		if in.operands[0]%2 != 0 {
+			return fail(in, "odd number of keys and values %d", in.operands[0])
+		}
+	case code.OpGetBuiltin:
+		if in.operands[0] >= len(object.Builtins) {
+			return fail(in, "builtin index %d out of range", in.operands[0])
+		}
+	case code.OpReturnValue, code.OpReturn, code.OpCurrentClosure:
+		if main {
+			return fail(in, "not allowed in the main program")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/object"
+	"strings"
+	"testing"
+)
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		`let f = fn(a, b) { let c = a + b; fn() { c * 2 } }; f(1, 2)()`,
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10)`,
+		`let f = fn(xs) { let s = 0; for (x in xs) { if (x > 2) { break; } s += x; } s }; f([1, 2, 3])`,
+		`let i = 0; while (i < 3) { i += 1; if (i == 2) { continue; } } {"a": [1, 2][0]}["a"]`,
+		`true && false || !true`,
+		`let f = fn() { for (x in [1]) { try { [x, if (x) { try { return 1; } finally { throw 2; } }]; } catch (e) { break; } } }; f()`,
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Errorf("%q: unexpected verifier error: %s", input, err)
+		}
+	}
+}
+
+func TestVerifyRejectsMalformedBytecode(t *testing.T) {
+	fn := func(numLocals, numParams int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals, NumParameters: numParams}
+	}
+
+	tests := []struct {
+		name     string
+		bytecode *compiler.Bytecode
+		expected string
+	}{
+		{
+			"unknown opcode",
+			&compiler.Bytecode{Instructions: code.Instructions{255}},
+			"invalid bytecode in <main>: 0000: opcode 255 undefined",
+		},
+		{
+			"truncated operand",
+			&compiler.Bytecode{Instructions: code.Instructions{byte(code.OpConstant), 0}},
+			"invalid bytecode in <main>: 0000: OpConstant: truncated operands",
+		},
+		{
+			"constant out of range",
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpConstant, 3), code.Make(code.OpPop))},
+			"0000 OpConstant: constant index 3 out of range",
+		},
+		{
+			"jump into an operand",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJumpNotTruthy, 2), code.Make(code.OpNull), code.Make(code.OpPop)),
+			},
+			"0001 OpJumpNotTruthy: jump target 2 is not an instruction boundary",
+		},
+		{
+			"jump past the end",
+			&compiler.Bytecode{Instructions: code.Make(code.OpJump, 100)},
+			"0000 OpJump: jump target 100 is not an instruction boundary",
+		},
+		{
+			"stack underflow",
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAdd))},
+			"0001 OpAdd: needs 2 values on the stack, has 1",
+		},
+		{
+			"unbalanced branches",
+			&compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpTrue),             // 0000
+					code.Make(code.OpJumpNotTruthy, 8), // 0001
+					code.Make(code.OpTrue),             // 0004
+					code.Make(code.OpJump, 8),          // 0005
+					code.Make(code.OpPop),              // 0008
+				),
+			},
+			"0008 OpPop: stack depth 0 on one path and 1 on another",
+		},
+		{
+			"local in main",
+			&compiler.Bytecode{Instructions: code.Make(code.OpGetLocal, 0)},
+			"0000 OpGetLocal: local index 0 out of range, the function has 0 locals",
+		},
+		{
+			"return in main",
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpReturnValue))},
+			"0001 OpReturnValue: not allowed in the main program",
+		},
+		{
+			"local beyond NumLocals",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(1, 0, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue))},
+			},
+			"invalid bytecode in function constant 0: 0000 OpGetLocal: local index 1 out of range, the function has 1 locals",
+		},
+		{
+			"free variable beyond the closure",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			},
+			"0000 OpGetFree: free variable index 0 out of range, the function has 0 free variables",
+		},
+		{
+			"function without return",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(0, 0, code.Make(code.OpNull))},
+			},
+			"0000 OpNull: control reaches the end of the function without a return",
+		},
+		{
+			"closure of a non-function",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"0000: OpClosure of a INTEGER constant",
+		},
+		{
+			"OpWide before an opcode without wide form",
+			&compiler.Bytecode{Instructions: concat([]byte{byte(code.OpWide)}, code.Make(code.OpJump, 0))},
+			"invalid bytecode in <main>: 0000: opcode OpJump cannot follow OpWide",
+		},
+		{
+			"wide local out of range",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(1, 0, code.MakeWide(code.OpGetLocal, 300), code.Make(code.OpReturnValue))},
+			},
+			"0000 OpGetLocal: local index 300 out of range, the function has 1 locals",
+		},
+		{
+			"handler inside an operand",
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpThrow), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+				Handlers:     code.HandlerTable{{Start: 0, End: 4, Target: 1}},
+			},
+			"invalid bytecode in <main>: invalid handler {Start:0 End:4 Target:1 Depth:0}",
+		},
+		{
+			"handler with a wrong depth",
+			&compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpTrue),  // 0000
+					code.Make(code.OpThrow), // 0001
+					code.Make(code.OpPop),   // 0002
+				),
+				Handlers: code.HandlerTable{{Start: 0, End: 2, Target: 2, Depth: 1}},
+			},
+			"0000 OpTrue: stack depth 0 is below the depth 1 of its handler",
+		},
+		{
+			"handler entered with a different depth",
+			&compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpNull), // 0000
+					code.Make(code.OpPop),  // 0001
+					code.Make(code.OpNull), // 0002
+					code.Make(code.OpPop),  // 0003
+				),
+				Handlers: code.HandlerTable{{Start: 0, End: 1, Target: 2}},
+			},
+			"0002 OpNull: stack depth 1 on one path and 0 on another",
+		},
+		{
+			"builtin out of range",
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop))},
+			"0000 OpGetBuiltin: builtin index 200 out of range",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		if err == nil {
+			t.Errorf("%s: expected a verifier error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error.\nwant=%q\ngot =%q", tt.name, tt.expected, err)
+		}
+	}
+}
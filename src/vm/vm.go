@@ -1,17 +1,31 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"monkey/code"
 	"monkey/compiler"
 	"monkey/object"
+	"monkey/token"
+	"strings"
 )
 
-const StackSize = 2048
-const GlobalSize = 65535
+// GlobalSize is the number of globals the 2-byte operand of OpGetGlobal
+// and OpSetGlobal can address.
+const GlobalSize = 65536
 
-// 预分配frame，一个是避免slice指针变化，简化代码处理，另一方面提高性能。
-const MaxFrames = 1024
+// The stack, frames and globals start small and grow on demand up to
+// Limits.MaxStackSize, Limits.MaxFrames and GlobalSize.
+const (
+	DefaultMaxStackSize = 1 << 20
+	DefaultMaxFrames    = 1 << 16
+
+	initialStackSize   = 256
+	initialFrames      = 16
+	initialGlobalsSize = 16
+)
 
 var True = &object.Boolean{Value: true}
 var False = &object.Boolean{Value: false}
@@ -23,19 +37,50 @@ type VM struct {
 	stack []object.Object
 	sp    int // always points to the next value. Top of stack is stack[sp-1]
 
-	globals []object.Object
+	globals  []object.Object
+	builtins []*object.Builtin // resolved by OpGetBuiltin, object.Builtins by default
 
 	frames     []*Frame
 	frameIndex int
+
+	limits    Limits
+	ctx       context.Context // checked every checkInterval instructions, nil when not cancelable
+	executed  int64           // instructions executed since Run, RunContext or CallContext
+	allocated int64           // approximate bytes allocated in the same period
 }
 
+// Limits bounds the resources a script may use. The zero value sets no
+// budgets and the default maximums for the stack and frames.
+type Limits struct {
+	MaxInstructions int64 // instructions per Run, RunContext or CallContext
+	MaxCallDepth    int   // nested function calls, builtins excluded
+	MaxMemory       int64 // approximate bytes allocated per Run, RunContext or CallContext
+
+	MaxStackSize int // stack slots, DefaultMaxStackSize when 0
+	MaxFrames    int // active frames including the main program, DefaultMaxFrames when 0
+}
+
+// checkInterval is how many instructions run between two checks for cancellation.
+const checkInterval = 1024
+
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.frameIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.frameIndex == len(vm.frames) {
+		max := vm.limits.MaxFrames
+		if max == 0 {
+			max = DefaultMaxFrames
+		}
+		if len(vm.frames) >= max {
+			return fmt.Errorf("stack overflow")
+		}
+		vm.frames = append(vm.frames, f)
+	}
 	vm.frames[vm.frameIndex] = f
 	vm.frameIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -57,46 +102,228 @@ func (f *Frame) Instructions() code.Instructions {
 	return f.cl.Fn.Instructions
 }
 
+func (f *Frame) functionName() string {
+	if f.cl.Fn.Name == "" {
+		return "<anonymous>"
+	}
+	return f.cl.Fn.Name
+}
+
+// Pos returns the source position of the instruction the frame is executing.
+func (f *Frame) Pos() token.Position {
+	return f.cl.Fn.LineTable.PosFor(f.ip)
+}
+
+// NewWithGlobalsStore returns a VM using s as its globals, e.g. the ones an
+// earlier VM left in Globals. s grows as needed and may be nil.
 func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
 	vm := New(bytecode)
 	vm.globals = s
 	return vm
 }
 
+// Globals returns the globals store. Growing it may have replaced the slice
+// passed to NewWithGlobalsStore, so callers sharing globals between VMs
+// must carry this one forward.
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
 func New(bytecode *compiler.Bytecode) *VM {
 	// main函数也作为一个function，用frame封装起来。
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Name:         "<main>",
+		Instructions: bytecode.Instructions,
+		LineTable:    bytecode.LineTable,
+		Handlers:     bytecode.Handlers,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, 1, initialFrames)
 	frames[0] = mainFrame
 
+	builtins := make([]*object.Builtin, len(object.Builtins))
+	for i, def := range object.Builtins {
+		builtins[i] = def.Builtin
+	}
+
 	return &VM{
 		constants: bytecode.Constants,
 
-		stack: make([]object.Object, StackSize),
+		stack: make([]object.Object, initialStackSize),
 		sp:    0,
 
-		globals:    make([]object.Object, GlobalSize),
+		globals:    make([]object.Object, initialGlobalsSize),
+		builtins:   builtins,
 		frames:     frames,
 		frameIndex: 1, // frameIndex 0 已经被mainFrame占用
 	}
 }
 
-// Run fetch-decode-execute
+// SetBuiltins replaces the builtins OpGetBuiltin indexes into, used by
+// embedders that register their own builtins with the compiler.
+func (vm *VM) SetBuiltins(builtins []*object.Builtin) {
+	vm.builtins = builtins
+}
+
+// SetLimits sets the budgets checked by Run, RunContext, Call and CallContext.
+// Exceeding the memory limit fails with an error wrapping ErrMemoryExceeded,
+// exceeding the others with one wrapping ErrBudgetExceeded.
+func (vm *VM) SetLimits(limits Limits) {
+	vm.limits = limits
+
+	// the stack only grows when full, so one larger than the maximum is cut
+	if max := limits.MaxStackSize; max > 0 && len(vm.stack) > max && vm.sp <= max {
+		vm.stack = vm.stack[:max]
+	}
+}
+
+// Run executes the bytecode. Errors are returned as *RuntimeError.
 func (vm *VM) Run() error {
+	vm.executed, vm.allocated = 0, 0
+	err := vm.run(0)
+	if err != nil {
+		return vm.newRuntimeError(err)
+	}
+	return nil
+}
+
+// RunContext is like Run but stops with an error wrapping ErrCanceled once
+// ctx is canceled or its deadline passes.
+func (vm *VM) RunContext(ctx context.Context) error {
+	previous := vm.ctx
+	vm.ctx = ctx
+	defer func() { vm.ctx = previous }()
+
+	if err := ctx.Err(); err != nil {
+		return vm.newRuntimeError(&canceledError{err})
+	}
+	return vm.Run()
+}
+
+// CallContext is like Call but honors ctx as RunContext does. The
+// instruction budget starts over, so it is meant for calls made while
+// the VM is idle; a builtin calling back into the running VM uses Call.
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...object.Object) (object.Object, error) {
+	previous := vm.ctx
+	vm.ctx = ctx
+	defer func() { vm.ctx = previous }()
+
+	if err := ctx.Err(); err != nil {
+		return nil, vm.newRuntimeError(&canceledError{err})
+	}
+	vm.executed, vm.allocated = 0, 0
+	return vm.Call(fn, args...)
+}
+
+// Call calls fn, a closure or a builtin, with args and returns its result.
+// It is re-entrant: a builtin called by the running VM may use it to call
+// back into Monkey. Errors are returned as *RuntimeError and leave the VM
+// as it was before the call.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	sp, frameIndex := vm.sp, vm.frameIndex
+
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+	if err == nil {
+		err = vm.executeCall(len(args))
+	}
+	if err == nil {
+		err = vm.run(frameIndex)
+	}
+	if err != nil {
+		runtimeErr := vm.newRuntimeError(err)
+		vm.sp, vm.frameIndex = sp, frameIndex
+		return nil, runtimeErr
+	}
+
+	return vm.pop(), nil
+}
+
+// run executes until the frames above stopFrame have returned, stopFrame 0
+// runs the main program to its end. Errors raised on the way go to the try
+// statements of those frames.
+func (vm *VM) run(stopFrame int) error {
+	for {
+		err := vm.execute(stopFrame)
+		if err == nil || !vm.catch(err, stopFrame) {
+			return err
+		}
+	}
+}
+
+// catch looks for a handler of err in the frames above stopFrame, innermost
+// first. If there is one, the frames above its own are dropped and execution
+// continues at its target with the caught value on top of the stack.
+func (vm *VM) catch(err error, stopFrame int) bool {
+	// running out of a budget cannot be caught, it would not stop the script
+	if errors.Is(err, ErrBudgetExceeded) || errors.Is(err, ErrMemoryExceeded) || errors.Is(err, ErrCanceled) {
+		return false
+	}
+
+	for i := vm.frameIndex - 1; i >= stopFrame; i-- {
+		frame := vm.frames[i]
+		handler, ok := frame.cl.Fn.Handlers.Find(frame.ip)
+		if !ok {
+			continue
+		}
+
+		sp := frame.basePointer + frame.cl.Fn.NumLocals + handler.Depth
+		if vm.ensureStack(sp+1) != nil {
+			return false
+		}
+		vm.frameIndex = i + 1
+		vm.sp = sp
+		vm.stack[vm.sp] = caughtValue(err)
+		vm.sp++
+		frame.ip = handler.Target - 1
+		return true
+	}
+
+	return false
+}
+
+// caughtValue is the value a catch block gets for err: the thrown value, or
+// the message of a runtime error.
+func caughtValue(err error) object.Object {
+	var exception *Exception
+	if errors.As(err, &exception) {
+		return exception.Value
+	}
+	return &object.String{Value: err.Error()}
+}
+
+// execute fetch-decode-execute until the frames above stopFrame have returned
+// or an instruction fails.
+func (vm *VM) execute(stopFrame int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.frameIndex > stopFrame && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		err := vm.checkBudget()
+		if err != nil {
+			return err
+		}
 
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
 
+		// OpWide doubles the operand widths of the next instruction
+		wide := op == code.OpWide
+		if wide {
+			vm.currentFrame().ip++
+			ip++
+			op = code.Opcode(ins[ip])
+		}
+
 		switch op {
 		case code.OpJump:
 			jumpPos := int(code.ReadUint16(ins[ip+1:]))
@@ -115,14 +342,13 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpConstant:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			constIndex := vm.readOperand(ins, 2, wide)
 
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
 				return err
 			}
-		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod:
 			err := vm.executeBinaryOperation(op)
 			if err != nil {
 				return err
@@ -139,7 +365,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpGreaterThan, code.OpNotEqual, code.OpEqual:
+		case code.OpGreaterThan, code.OpGreaterThanOrEqual, code.OpNotEqual, code.OpEqual:
 			err := vm.executeComparison(op)
 			if err != nil {
 				return err
@@ -157,31 +383,45 @@ func (vm *VM) Run() error {
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
+
+			if int(globalIndex) >= len(vm.globals) {
+				vm.growGlobals(int(globalIndex) + 1)
+			}
 			vm.globals[globalIndex] = vm.pop()
 
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			var global object.Object
+			if int(globalIndex) < len(vm.globals) {
+				global = vm.globals[globalIndex]
+			}
+			err := vm.pushVariable(global)
 			if err != nil {
 				return err
 			}
 		case code.OpArray:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := vm.readOperand(ins, 2, wide)
 
+			err := vm.allocate(arraySize(numElements))
+			if err != nil {
+				return err
+			}
 			array := vm.buildArray(vm.sp-numElements, vm.sp)
 			vm.sp = vm.sp - numElements
 
-			err := vm.push(array)
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
 		case code.OpHash:
-			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			numElements := vm.readOperand(ins, 2, wide)
 
+			err := vm.allocate(hashSize(numElements / 2))
+			if err != nil {
+				return err
+			}
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
 				return err
@@ -200,27 +440,64 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpCall:
+		case code.OpIn:
+			container := vm.pop()
+			value := vm.pop()
 
-			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			found, err := object.Contains(container, value)
+			if err != nil {
+				return err
+			}
+			err = vm.push(nativeBoolToBoolean(found))
+			if err != nil {
+				return err
+			}
+		case code.OpCall:
+			numArgs := vm.readOperand(ins, 1, wide)
 
-			err := vm.executeCall(int(numArgs))
+			err := vm.executeCall(numArgs)
 			if err != nil {
 				return err
 			}
 		case code.OpSetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			localIndex := vm.readOperand(ins, 1, wide)
 
 			frame := vm.currentFrame()
-			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+			slot := frame.basePointer + int(localIndex)
+			// a local captured by a closure lives in a cell shared with the closure
+			if cell, ok := vm.stack[slot].(*object.Cell); ok {
+				cell.Value = vm.pop()
+			} else {
+				vm.stack[slot] = vm.pop()
+			}
 		case code.OpGetLocal:
-			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			localIndex := vm.readOperand(ins, 1, wide)
 
 			frame := vm.currentFrame()
-			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
+			value := vm.stack[frame.basePointer+int(localIndex)]
+			if cell, ok := value.(*object.Cell); ok {
+				value = cell.Value
+			}
+
+			err := vm.pushVariable(value)
+			if err != nil {
+				return err
+			}
+		case code.OpCaptureLocal:
+			localIndex := vm.readOperand(ins, 1, wide)
+
+			slot := vm.currentFrame().basePointer + int(localIndex)
+			cell, ok := vm.stack[slot].(*object.Cell)
+			if !ok {
+				err := vm.allocate(objectSize)
+				if err != nil {
+					return err
+				}
+				cell = &object.Cell{Value: vm.stack[slot]}
+				vm.stack[slot] = cell
+			}
+
+			err := vm.push(cell)
 			if err != nil {
 				return err
 			}
@@ -249,39 +526,139 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpGetBuiltin:
-			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-			definition := object.Builtins[builtinIndex]
+			builtinIndex := vm.readOperand(ins, 1, wide)
+			if builtinIndex >= len(vm.builtins) {
+				return fmt.Errorf("undefined builtin %d", builtinIndex)
+			}
 
-			err := vm.push(definition.Builtin)
+			err := vm.push(vm.builtins[builtinIndex])
 			if err != nil {
 				return err
 			}
 		case code.OpClosure:
-			constIndex := code.ReadUint16(ins[ip+1:])
-			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
+			constIndex := vm.readOperand(ins, 2, wide)
+			numFree := vm.readOperand(ins, 1, wide)
+
+			err := vm.pushClosure(constIndex, numFree)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterInit:
+			iterable := vm.pop()
+			iterator, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
 
-			err := vm.pushClosure(int(constIndex), int(numFree))
+			err := vm.push(iterator)
+			if err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			jumpPos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			iterator, ok := vm.pop().(*object.Iterator)
+			if !ok {
+				return fmt.Errorf("OpIterNext without an iterator")
+			}
+			el, ok := iterator.Next()
+			if !ok {
+				vm.currentFrame().ip = jumpPos - 1
+				break
+			}
+
+			err := vm.push(el)
+			if err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			err := vm.push(currentClosure)
 			if err != nil {
 				return err
 			}
 
 		case code.OpGetFree:
-			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			freeIndex := vm.readOperand(ins, 1, wide)
+
+			currentClosure := vm.currentFrame().cl
+			err := vm.pushVariable(currentClosure.Free[freeIndex].Value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpSetFree:
+			freeIndex := vm.readOperand(ins, 1, wide)
+
+			currentClosure := vm.currentFrame().cl
+			currentClosure.Free[freeIndex].Value = vm.pop()
+
+		case code.OpCaptureFree:
+			freeIndex := vm.readOperand(ins, 1, wide)
 
 			currentClosure := vm.currentFrame().cl
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+
+		case code.OpThrow:
+			return &Exception{Value: vm.pop()}
 		}
 	}
 
 	return nil
 }
 
+// readOperand reads the next operand of the executing instruction, width
+// bytes wide or twice that after OpWide, and moves the ip past it.
+func (vm *VM) readOperand(ins code.Instructions, width int, wide bool) int {
+	frame := vm.currentFrame()
+	operand := ins[frame.ip+1:]
+	if wide {
+		width *= 2
+	}
+	frame.ip += width
+
+	switch width {
+	case 1:
+		return int(code.ReadUint8(operand))
+	case 2:
+		return int(code.ReadUint16(operand))
+	default:
+		return int(code.ReadUint32(operand))
+	}
+}
+
+// checkBudget counts the instruction about to be executed against the
+// instruction budget and polls the context every checkInterval instructions.
+func (vm *VM) checkBudget() error {
+	vm.executed++
+	if max := vm.limits.MaxInstructions; max > 0 && vm.executed > max {
+		return fmt.Errorf("%w: more than %d instructions executed", ErrBudgetExceeded, max)
+	}
+	if vm.ctx != nil && vm.executed%checkInterval == 0 {
+		if err := vm.ctx.Err(); err != nil {
+			return &canceledError{err}
+		}
+	}
+	return nil
+}
+
 func (vm *VM) executeCall(numArgs int) error {
 	callee := vm.stack[vm.sp-1-numArgs]
 	switch callee := callee.(type) {
@@ -293,16 +670,26 @@ func (vm *VM) executeCall(numArgs int) error {
 		return fmt.Errorf("calling non-function and non-built-in")
 	}
 }
-func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) (e error) {
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
-	result := builtin.Fn(args...)
+	result, err := builtin.Fn(args...)
+	if err != nil {
+		return err
+	}
+	// an error object is an error too, as in the evaluator
+	if errObj, ok := result.(*object.Error); ok {
+		return errors.New(errObj.Message)
+	}
 	vm.sp = vm.sp - numArgs - 1
-	if result != nil {
-		e = vm.push(result)
-	} else {
-		e = vm.push(Null)
+
+	if result == nil {
+		return vm.push(Null)
 	}
-	return e
+	err = vm.allocate(sizeOf(result))
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
@@ -310,13 +697,29 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
 			cl.Fn.NumParameters, numArgs)
 	}
+	// frameIndex counts the main frame, so this allows MaxCallDepth nested calls
+	if max := vm.limits.MaxCallDepth; max > 0 && vm.frameIndex > max {
+		return fmt.Errorf("%w: call depth exceeds %d", ErrBudgetExceeded, max)
+	}
 
 	// 把函数放到一个新的frame作为current frame（在main frame上面）
 	// 到下一个循环时，就会取对应新的frame的指令执行了
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	err := vm.ensureStack(frame.basePointer + cl.Fn.NumLocals)
+	if err != nil {
+		return err
+	}
+	err = vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
+	// clear the locals: stale cells from an earlier call must not be written through
+	for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+		vm.stack[i] = nil
+	}
+
 	return nil
 }
 
@@ -357,16 +760,45 @@ func (vm *VM) executeArrayIndex(left object.Object, index object.Object) error {
 func (vm *VM) exeucteHashIndex(left object.Object, index object.Object) error {
 	hashObj := left.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	value, ok, err := hashObj.Get(index)
+	if err != nil {
+		return err
 	}
-
-	pair, ok := hashObj.Pairs[key.HashKey()]
 	if !ok {
 		return vm.push(Null)
 	}
-	return vm.push(pair.Value)
+	return vm.push(value)
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+	case *object.Hash:
+		_, ok, err := left.Get(index)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := vm.allocate(hashPairSize); err != nil {
+				return err
+			}
+		}
+		if err := left.Set(index, value); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("index assignment is not supported: %s", left.Type())
+	}
+
+	return vm.push(value)
 }
 
 func (vm *VM) executeBangOperator() error {
@@ -385,11 +817,14 @@ func (vm *VM) executeBangOperator() error {
 
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
-	if operand.Type() != object.INTEGER_OBJ {
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
-	val := operand.(*object.Integer).Value
-	return vm.push(&object.Integer{Value: -val})
 }
 
 func (vm *VM) executeBinaryOperation(op code.Opcode) error {
@@ -401,13 +836,34 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	switch {
 	case leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ:
 		return vm.executeBinaryIntegerOperation(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeBinaryFloatOperation(op, toFloat(left), toFloat(right))
 	case leftType == object.STRING_OBJ && rightType == object.STRING_OBJ:
 		return vm.executeBinaryStringOperation(op, left, right)
+	case leftType == object.STRING_OBJ && rightType == object.INTEGER_OBJ && op == code.OpMul:
+		return vm.executeStringRepetition(left.(*object.String).Value, right.(*object.Integer).Value)
 	default:
 		return fmt.Errorf("unsupported types for binary operation: %s %s", leftType, rightType)
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	t := obj.Type()
+	return t == object.INTEGER_OBJ || t == object.FLOAT_OBJ
+}
+
+// toFloat converts an Integer or Float to float64 for mixed arithmetic.
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
 func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
@@ -416,11 +872,19 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 		return vm.executeIntegerComparison(op, left, right)
 	}
 
+	if isNumber(left) && isNumber(right) {
+		return vm.executeFloatComparison(op, toFloat(left), toFloat(right))
+	}
+
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left.(*object.String).Value, right.(*object.String).Value)
+	}
+
 	switch op {
 	case code.OpEqual:
-		return vm.push(nativeBoolToBoolean(right == left))
+		return vm.push(nativeBoolToBoolean(object.Equals(left, right)))
 	case code.OpNotEqual:
-		return vm.push(nativeBoolToBoolean(right != left))
+		return vm.push(nativeBoolToBoolean(!object.Equals(left, right)))
 	default:
 		return fmt.Errorf("unknown operator: %d (%s %s)", op, left.Type(), right.Type())
 	}
@@ -437,6 +901,39 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left object.Object, right
 		return vm.push(nativeBoolToBoolean(lv != rv))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBoolean(lv > rv))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBoolean(lv >= rv))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+func (vm *VM) executeFloatComparison(op code.Opcode, lv, rv float64) error {
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBoolean(lv == rv))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBoolean(lv != rv))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBoolean(lv > rv))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBoolean(lv >= rv))
+	default:
+		return fmt.Errorf("unknown operator: %d", op)
+	}
+}
+
+// executeStringComparison orders strings lexicographically by bytes.
+func (vm *VM) executeStringComparison(op code.Opcode, lv, rv string) error {
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBoolean(lv == rv))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBoolean(lv != rv))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBoolean(lv > rv))
+	case code.OpGreaterThanOrEqual:
+		return vm.push(nativeBoolToBoolean(lv >= rv))
 	default:
 		return fmt.Errorf("unknown operator: %d", op)
 	}
@@ -457,9 +954,30 @@ func (vm *VM) executeBinaryStringOperation(op code.Opcode, left, right object.Ob
 	rightValue := right.(*object.String).Value
 	leftValue := left.(*object.String).Value
 
+	err := vm.allocate(objectSize + int64(len(leftValue)+len(rightValue)))
+	if err != nil {
+		return err
+	}
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
+// executeStringRepetition pushes s repeated count times, "ab" * 3.
+func (vm *VM) executeStringRepetition(s string, count int64) error {
+	if count < 0 {
+		return fmt.Errorf("negative string repetition count: %d", count)
+	}
+	size := int64(len(s)) * count
+	if count > 0 && size/count != int64(len(s)) || size > math.MaxInt32 {
+		return fmt.Errorf("string repetition too large: %d * %d bytes", count, len(s))
+	}
+
+	err := vm.allocate(objectSize + size)
+	if err != nil {
+		return err
+	}
+	return vm.push(&object.String{Value: strings.Repeat(s, int(count))})
+}
+
 func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
 	rightValue := right.(*object.Integer).Value
 	leftValue := left.(*object.Integer).Value
@@ -475,15 +993,47 @@ func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.O
 	case code.OpMul:
 		result = leftValue * rightValue
 	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
 		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
 	}
 
 	return vm.push(&object.Integer{Value: result})
 }
 
+func (vm *VM) executeBinaryFloatOperation(op code.Opcode, leftValue, rightValue float64) error {
+	var result float64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		result = leftValue / rightValue
+	case code.OpMod:
+		result = math.Mod(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+
+	return vm.push(&object.Float{Value: result})
+}
+
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		err := vm.ensureStack(vm.sp + 1)
+		if err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -492,6 +1042,56 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// ensureStack grows the stack to at least size slots.
+func (vm *VM) ensureStack(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+
+	max := vm.limits.MaxStackSize
+	if max == 0 {
+		max = DefaultMaxStackSize
+	}
+	if size > max {
+		return fmt.Errorf("stack overflow")
+	}
+
+	newSize := 2 * len(vm.stack)
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > max {
+		newSize = max
+	}
+	stack := make([]object.Object, newSize)
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+// growGlobals grows the globals to at least size slots, size <= GlobalSize.
+func (vm *VM) growGlobals(size int) {
+	newSize := 2 * len(vm.globals)
+	if newSize < size {
+		newSize = size
+	}
+	if newSize > GlobalSize {
+		newSize = GlobalSize
+	}
+	globals := make([]object.Object, newSize)
+	copy(globals, vm.globals)
+	vm.globals = globals
+}
+
+// pushVariable pushes the value of a variable, which is nil when the
+// variable is read before it is set, e.g. by `let x = x + 1`.
+func (vm *VM) pushVariable(value object.Object) error {
+	if value == nil {
+		return fmt.Errorf("variable used before it is set")
+	}
+	return vm.push(value)
+}
+
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
 	vm.sp--
@@ -513,23 +1113,15 @@ func (vm *VM) buildArray(spStart int, spEnd int) *object.Array {
 }
 
 func (vm *VM) buildHash(spStart int, spEnd int) (*object.Hash, error) {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hash := object.NewHash((spEnd - spStart) / 2)
 
 	for i := spStart; i < spEnd; i += 2 {
-
-		k := vm.stack[i]
-		v := vm.stack[i+1]
-		pair := object.HashPair{Key: k, Value: v}
-
-		hashKey, ok := k.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", k.Type())
+		if err := hash.Set(vm.stack[i], vm.stack[i+1]); err != nil {
+			return nil, err
 		}
-
-		pairs[hashKey.HashKey()] = pair
 	}
 
-	return &object.Hash{Pairs: pairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) pushClosure(constIndex int, numFree int) error {
@@ -539,9 +1131,21 @@ func (vm *VM) pushClosure(constIndex int, numFree int) error {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
 
-	free := make([]object.Object, numFree)
+	err := vm.allocate(closureSize(numFree))
+	if err != nil {
+		return err
+	}
+
+	// free variables are pushed as cells by OpCaptureLocal/OpCaptureFree,
+	// anything else (e.g. the current closure) is boxed here
+	free := make([]*object.Cell, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		v := vm.stack[vm.sp-numFree+i]
+		cell, ok := v.(*object.Cell)
+		if !ok {
+			cell = &object.Cell{Value: v}
+		}
+		free[i] = cell
 	}
 	vm.sp = vm.sp - numFree
 
@@ -1,13 +1,19 @@
 package vm
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"monkey/ast"
+	"monkey/code"
 	"monkey/compiler"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTestCase struct {
@@ -38,6 +44,60 @@ func TestRecursiveFibonacci(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestRecursiveClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+        let wrapper = fn() {
+            let countDown = fn(x) {
+                if (x == 0) {
+                    return 0;
+                } else {
+                    countDown(x - 1);
+                }
+            };
+            countDown(1);
+        };
+        wrapper();
+        `,
+			expected: 0,
+		},
+		{
+			input: `
+        let wrapper = fn() {
+            let fibonacci = fn(x) {
+                if (x == 0) {
+                    return 0;
+                } else {
+                    if (x == 1) {
+                        return 1;
+                    } else {
+                        fibonacci(x - 1) + fibonacci(x - 2);
+                    }
+                }
+            };
+            fibonacci(15);
+        };
+        wrapper();
+        `,
+			expected: 610,
+		},
+	}
+	runVmTests(t, tests)
+}
+
+// A function calling itself by its name calls whatever the name is bound to
+// when it runs, as in the evaluator.
+func TestReassignedRecursiveClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{`let f = fn() { f() }; let g = f; f = fn() { 0 }; g();`, 0},
+		{`let wrapper = fn() { let f = fn() { f() }; let g = f; f = fn() { 1 }; g() }; wrapper();`, 1},
+		{`let wrapper = fn() { let f = fn() { f() }; let g = f; let f = fn() { 2 }; g() }; wrapper();`, 2},
+		{`let wrapper = fn() { let f = fn(x) { if (x == 0) { f = fn(x) { 3 }; } f(x - 1) }; f(1) }; wrapper();`, 3},
+	}
+	runVmTests(t, tests)
+}
+
 // 整体思路:
 // 分词阶段：string -> token -> ast node
 
@@ -92,36 +152,83 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{Message: "argument to `len` not supported, got INTEGER"},
-		},
-		{`len("one", "two")`,
-			&object.Error{Message: "wrong number of arguments. got=2, want=1"},
-		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{Message: "argument to `first` must be ARRAY, got INTEGER"},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{Message: "argument to `last` must be ARRAY, got INTEGER"},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
-		{`push(1, 1)`,
-			&object.Error{Message: "argument to `push` must be ARRAY, got INTEGER"},
-		},
+		{`keys({3: 1, 1: 2, 2: 3})`, []int{3, 1, 2}},
+		{`values({3: 1, 1: 2, 2: 3})`, []int{1, 2, 3}},
+		{`let h = {2: 0, 1: 0}; h[3] = 0; h[2] = 0; keys(h)`, []int{2, 1, 3}},
+		{`keys({})`, []int{}},
 	}
 	runVmTests(t, tests)
 }
 
+func TestBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`first(1)`, "argument to `first` must be ARRAY, got INTEGER"},
+		{`last(1)`, "argument to `last` must be ARRAY, got INTEGER"},
+		{`push(1, 1)`, "argument to `push` must be ARRAY, got INTEGER"},
+		{`keys([1])`, "argument to `keys` must be HASH, got ARRAY"},
+		{`values({}, {})`, "wrong number of arguments. got=2, want=1"},
+		{`let f = fn(x) { int(x) }; let y = f("a"); puts("unreachable"); y`, `could not parse "a" as integer`},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Err.Error() != tt.expected {
+			t.Errorf("%q: wrong VM error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// the traceback shows the function calling the builtin
+	comp := compiler.New()
+	err := comp.Compile(parse("let f = fn(x) {\n  len(x)\n};\nf(1)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	expected := "runtime error: argument to `len` not supported, got INTEGER\n    at f (2:6)\n    at <main> (4:2)\n"
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q", expected, runtimeErr.Traceback())
+	}
+
+	// builtins may still return error objects, they stop the VM as well
+	builtins := []*object.Builtin{{Fn: func(args ...object.Object) (object.Object, error) {
+		return &object.Error{Message: "legacy"}, nil
+	}}}
+	comp = compiler.New()
+	err = comp.Compile(parse("len(1)"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := New(comp.Bytecode())
+	machine.SetBuiltins(builtins)
+	if err := machine.Run(); err == nil || err.Error() != "legacy" {
+		t.Errorf("wrong error for an error object: %v", err)
+	}
+}
+
 func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -159,6 +266,46 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 }
 
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+let outer = fn() { add(1, "two") };
+outer();`
+
+	program := parse(input)
+
+	comp := compiler.New()
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err == nil {
+		t.Fatalf("expected VM error but resulted in none.")
+	}
+
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+
+	expected := `runtime error: unsupported types for binary operation: INTEGER STRING
+    at add (2:5)
+    at outer (4:23)
+    at <main> (5:6)
+`
+	if runtimeErr.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q", expected, runtimeErr.Traceback())
+	}
+
+	if runtimeErr.Pos().String() != "2:5" {
+		t.Errorf("wrong error position. want=%q, got=%q", "2:5", runtimeErr.Pos())
+	}
+}
+
 func TestCallingFunctionsWithArgumentsAndBindings(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -324,6 +471,13 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{`{[1, "a"]: 1}[[1, "a"]]`, 1},
+		{`{[1, [2]]: 1}[[1, [2]]]`, 1},
+		{`{[1]: 1}[[1.0]]`, 1},
+		{`{1: 1}[1.0]`, 1},
+		{`{1.5: 1}[1.5]`, 1},
+		{`{1: 1}[true]`, Null},
+		{`let h = {}; h[[1, 2]] = 1; h[[1, 2]] = 2; h[[1, 2]]`, 2},
 	}
 
 	runVmTests(t, tests)
@@ -353,6 +507,62 @@ func TestHashLiterals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{b: 4, a: 2, c: 3}"},
+		{`let out = ""; for (k in {"b": 1, "a": 2, "c": 3}) { out = out + k }; out`, "bac"},
+		{`values({"z": [1], "y": 2.5})`, "[[1], 2.5]"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestSelfReferences(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; a[0] = a; a`, "[[...]]"},
+		{`let h = {}; h["self"] = h; h`, "{self: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; a`, "[{a: [...]}]"},
+		{`let b = [1]; let a = [b, b]; a`, "[[1], [1]]"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("%q: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []vmTestCase{
 		{"[]", []int{}},
@@ -369,11 +579,58 @@ func TestStringExpressions(t *testing.T) {
 		{`"monkey"`, "monkey"},
 		{`"mon" + "key"`, "monkey"},
 		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"ab" * 3`, "ababab"},
+		{`"ab" * 0`, ""},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"ab" < "abc"`, true},
+		{`"b" > "abc"`, true},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
+		{`"a" in "cat"`, true},
+		{`"dog" in "cat"`, false},
+		{`"" in ""`, true},
+		{`2 in [1, 2, 3]`, true},
+		{`[2] in [1, [2]]`, true},
+		{`4 in []`, false},
+		{`"k" in {"k": 1}`, true},
+		{`1 in {"1": 1}`, false},
+		{`1.0 in [1]`, true},
+		{`1.0 in {1: 0}`, true},
+		{`len(keys({1: "i", 1.0: "f"}))`, 1},
+		{`let x = 3; !(x in [1, 2]) && "ab" * 2 in "xabab"`, true},
 	}
 
 	runVmTests(t, tests)
 }
 
+func TestStringOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"ab" * -1`, "negative string repetition count: -1"},
+		{`"ab" * 4611686018427387904`, "string repetition too large: 4611686018427387904 * 2 bytes"},
+		{`3 * "ab"`, "unsupported types for binary operation: INTEGER STRING"},
+		{`1 in "cat"`, "left operand of in must be STRING, got INTEGER"},
+		{`{} in {}`, "unusable as hash key: HASH"},
+		{`1 in 2`, "in operator is not supported: INTEGER"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%q: wrong VM error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func TestGlobalLetStatments(t *testing.T) {
 
 	tests := []vmTestCase{
@@ -410,6 +667,41 @@ func TestBooleanExpressions(t *testing.T) {
 		{"!!false", false},
 		{"!!5", true},
 		{"!(if (false) { 5; })", true},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"1 && 0", true},
+		{"false || true", true},
+		{"false || false", false},
+		{"(if (false) { 1 }) || false", false},
+		{"1 < 2 && 2 < 3 || false", true},
+		{"let x = 0; false && (x = 1); true || (x = 2); x", 0},
+		{"let x = 0; true && (x = 1); false || (x = 2); x", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`let a = "x"; let b = "x"; a == b`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] != [1, 2]`, false},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "b"]] == [1, [2, "b"]]`, true},
+		{`[1] == [1.0]`, true},
+		{`{"a": [1], "b": 2} == {"b": 2, "a": [1]}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == [1]`, false},
+		{`(if (false) { 1 }) == (if (false) { 2 })`, true},
+		{`(if (false) { 1 }) == false`, false},
+		{`true == true`, true},
+		{`"1" == 1`, false},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
 	}
 
 	runVmTests(t, tests)
@@ -451,6 +743,514 @@ func TestIntegerArithmetic(t *testing.T) {
 		{"-10", -10},
 		{"-50 + 100 + -50", 0},
 		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 + 10 % 4 * 3", 8},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
+		{"1.5 <= 1", false},
+		{"7.5 % 2", 1.5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let find = fn(arr, n) {
+			for (x in arr) {
+				if (x < n) { continue; }
+				if (x == n) { return x * 10; }
+				break;
+			}
+			-1
+		};
+		[find([1, 2, 3], 2), find([1, 5, 3], 3), find([], 1)]
+		`, []int{20, -1, -1}},
+		{`
+		let product = fn() {
+			for (x in [1, 2, 3]) {
+				for (y in [10, 20]) {
+					if (x * y == 40) { return [x, y]; }
+				}
+			}
+		};
+		product()
+		`, []int{2, 20}},
+		{`
+		let last = fn(s) { let c = ""; for (ch in s) { let c = ch; } c };
+		last("abc")
+		`, "c"},
+		{`
+		let loop = fn() { while (true) { return 42; } };
+		loop()
+		`, 42},
+		{`
+		let f = fn() { while (true) { break; } 7 };
+		f()
+		`, 7},
+		{`
+		let f = fn() { for (x in [1, 2]) { let g = fn() { x }; if (x == 2) { return g; } } };
+		f()()
+		`, 2},
+		{`if (true) { for (x in []) { x } }`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{`let r = 0; try { throw 5; r = 1; } catch (e) { r = e; } r`, 5},
+		{`let r = 0; try { [1][0] + "a"; } catch (e) { r = e; } r`,
+			"unsupported types for binary operation: INTEGER STRING"},
+		{`let r = 0; try { len(1); } catch (e) { r = e; } r`,
+			"argument to `len` not supported, got INTEGER"},
+		{`let r = []; try { r = push(r, 1); } finally { r = push(r, 2); } r`, []int{1, 2}},
+		{`
+		let r = 0;
+		try {
+			try { throw 1; } finally { try { try { throw 2; } finally {} } catch (e) {} }
+		} catch (e) { r = e; }
+		r
+		`, 1},
+		{`
+		let r = [];
+		try { try { throw 1; } finally { r = push(r, 2); } } catch (e) { r = push(r, e); }
+		r
+		`, []int{2, 1}},
+		{`
+		let r = 0;
+		try { try { throw 1; } catch (e) { throw e + 1; } } catch (e) { r = e; }
+		r
+		`, 2},
+		{`
+		let f = fn(n) { if (n == 0) { throw "bottom"; } f(n - 1) };
+		let g = fn() { try { f(10) } catch (e) { return e; } };
+		g()
+		`, "bottom"},
+		{`
+		let log = [];
+		let f = fn() { try { return 1; } finally { log = push(log, 2); } };
+		[f(), log[0]]
+		`, []int{1, 2}},
+		{`
+		let f = fn() { try { return 1; } finally { return 2; } };
+		f()
+		`, 2},
+		{`
+		let f = fn() {
+			let log = [];
+			for (x in [1, 2, 3, 4]) {
+				try {
+					if (x == 2) { continue; }
+					if (x == 4) { break; }
+					log = push(log, x);
+				} finally {
+					log = push(log, x * 10);
+				}
+			}
+			log
+		};
+		f()
+		`, []int{1, 10, 20, 3, 30, 40}},
+		{`
+		let f = fn() {
+			try {
+				while (true) { try { break; } finally { throw "from finally"; } }
+			} catch (e) {
+				return e;
+			}
+		};
+		f()
+		`, "from finally"},
+		{`[1, 2, if (true) { try { throw 3; } catch (e) { 4 }; 5 }]`, []int{1, 2, 5}},
+		{`
+		let r = 0;
+		let i = 0;
+		while (i < 100) { try { i = i + 1; throw i; } catch (e) { r = r + e; } }
+		r
+		`, 5050},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse("let f = fn() {\n  throw {\"code\": 1};\n};\nf()"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	var exception *Exception
+	if !errors.As(err, &exception) || exception.Value.Inspect() != "{code: 1}" {
+		t.Fatalf("expected an *Exception, got=%v", err)
+	}
+	expected := "runtime error: uncaught exception: {code: 1}\n    at f (2:3)\n    at <main> (4:2)\n"
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Traceback() != expected {
+		t.Errorf("wrong traceback.\nwant=%q\ngot =%q", expected, runtimeErr.Traceback())
+	}
+
+	// running out of a budget cannot be caught
+	comp = compiler.New()
+	err = comp.Compile(parse(`try { while (true) { } } catch (e) { }`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	vm.SetLimits(Limits{MaxInstructions: 1000})
+	err = vm.Run()
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("expected ErrBudgetExceeded, got=%v", err)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"1 / 0", "1 % 0"} {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil || err.Error() != "division by zero" {
+			t.Errorf("wrong VM error for %q: %v", input, err)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{`let x = 1; x = 2; x`, 2},
+		{`let x = 1; x += 2`, 3},
+		{`let x = 10; x -= 3; x *= 2; x /= 7; x`, 2},
+		{`let x = 1; let x = x + 1; x`, 2},
+		{`let f = fn() { let y = 1; y = y + 1; y }; f()`, 2},
+		{`let a = 0; let b = 0; a = b = 5; [a, b]`, []int{5, 5}},
+		{`let a = [1, 2, 3]; a[1] = 5; a`, []int{1, 5, 3}},
+		{`let a = [1, 2, 3]; a[2] *= 10; a`, []int{1, 2, 30}},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{`let a = [0, 0]; let b = [5]; a[b[0] -= 4] += 10; a`, []int{0, 10}},
+		{`let a = [[1], [2]]; let b = [1]; (a[b[0] *= 1])[0] += 5; a[1]`, []int{7}},
+		{`
+		let counter = fn() {
+			let n = 0;
+			fn() { n += 1; n }
+		};
+		let c = counter();
+		c(); c();
+		let d = counter();
+		[c(), d()]
+		`, []int{3, 1}},
+		{`
+		let f = fn() {
+			let n = 0;
+			let inc = fn() { n = n + 1 };
+			let get = fn() { n };
+			inc(); inc();
+			[get(), n]
+		};
+		f()
+		`, []int{2, 2}},
+		{`
+		let outer = fn() {
+			let n = 0;
+			let middle = fn() { fn() { n += 10 } };
+			middle()();
+			n
+		};
+		outer()
+		`, 10},
+		{`
+		let sum = fn(arr) { let total = 0; for (x in arr) { total += x; } total };
+		sum([1, 2, 3, 4])
+		`, 10},
+		{`let i = 0; while (i < 5) { i += 1; } i`, 5},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUnsetVariables(t *testing.T) {
+	tests := []string{
+		`let z = z + 1;`,
+		`fn() { let z = z + 1; z }();`,
+		`let f = fn() { let x = (fn() { x })(); x }; f() + 1;`,
+	}
+
+	for _, input := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Err.Error() != "variable used before it is set" {
+			t.Errorf("%q: wrong VM error: %v", input, err)
+		}
+	}
+
+	// a global the VM has not grown to yet
+	bytecode := &compiler.Bytecode{Instructions: concat(
+		code.Make(code.OpGetGlobal, 100),
+		code.Make(code.OpPop),
+	)}
+	err := New(bytecode).Run()
+	if err == nil || !strings.Contains(err.Error(), "variable used before it is set") {
+		t.Errorf("wrong VM error for an unset global: %v", err)
+	}
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1]; a[1] = 2`, "index out of range: 1"},
+		{`let a = [1]; a["x"] = 2`, "array index must be INTEGER, got STRING"},
+		{`let h = {}; h[fn() {}] = 1`, "unusable as hash key: CLOSURE"},
+		{`{[1, {}]: 1}`, "unusable as hash key: HASH"},
+		{`let a = [1]; a[0] = a; {a: 1}`, "unusable as hash key: ARRAY containing itself"},
+		{`let s = "a"; s[0] = "b"`, "index assignment is not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q but resulted in none.", tt.input)
+		}
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Err.Error() != tt.expected {
+			t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   Limits
+		expected string // "" when the program must finish
+	}{
+		{`let i = 0; while (i < 10) { i += 1; }`, Limits{MaxInstructions: 1000}, ""},
+		{`while (true) { }`, Limits{MaxInstructions: 1000}, "budget exceeded: more than 1000 instructions executed"},
+		{`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(9)`, Limits{MaxCallDepth: 10}, ""},
+		{`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(10)`, Limits{MaxCallDepth: 10}, "budget exceeded: call depth exceeds 10"},
+		{`let f = fn() { f() }; f()`, Limits{MaxCallDepth: 100}, "budget exceeded: call depth exceeds 100"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.Run()
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrBudgetExceeded) || err.Error() != tt.expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		input  string
+		limit  int64
+		exceed bool
+	}{
+		{`let a = []; let i = 0; while (i < 10) { a = push(a, i); i += 1; }`, 10000, false},
+		{`let a = []; while (true) { a = push(a, 1); }`, 100000, true},
+		{`let s = "x"; while (true) { s = s + s; }`, 1 << 20, true},
+		{`let h = {}; let i = 0; while (true) { h[i] = i; i += 1; }`, 100000, true},
+		{`let f = fn() { [1, 2, 3] }; while (true) { f(); }`, 100000, true},
+		{`let f = fn(x) { fn() { x } }; while (true) { f(1); }`, 100000, true},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(Limits{MaxMemory: tt.limit})
+		err = vm.Run()
+		if !tt.exceed {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %s", tt.input, err)
+			}
+			continue
+		}
+		expected := fmt.Sprintf("memory limit exceeded: more than %d bytes allocated", tt.limit)
+		if !errors.Is(err, ErrMemoryExceeded) || err.Error() != expected {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, expected, err)
+		}
+	}
+}
+
+func TestGrowingStack(t *testing.T) {
+	var lets bytes.Buffer
+	for i := 0; i < 100; i++ {
+		// identifiers cannot contain digits
+		fmt.Fprintf(&lets, "let g%c%c = %d; ", 'a'+i/26, 'a'+i%26, i)
+	}
+
+	tests := []vmTestCase{
+		// deeper than the stack and frames the VM starts with
+		{`let f = fn(n) { if (n == 0) { return 0; } 1 + f(n - 1) }; f(10000)`, 10000},
+		{`let f = fn(a, b, c, d, e) { let x = [a, b, c, d, e]; x[4] }; let g = fn(n) { if (n == 0) { return f(1, 2, 3, 4, 5); } g(n - 1) }; g(500)`, 5},
+		{lets.String() + "gaa + gdv", 99},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestWideOperands(t *testing.T) {
+	names := make([]string, 300)
+	for i := range names {
+		// identifiers cannot contain digits
+		names[i] = fmt.Sprintf("v%c%c", 'a'+i/26, 'a'+i%26)
+	}
+	numbers := make([]string, 70000)
+	for i := range numbers {
+		numbers[i] = fmt.Sprint(i)
+	}
+	pairs := make([]string, 40000)
+	for i := range pairs {
+		pairs[i] = fmt.Sprintf("%d: %d", i, i)
+	}
+	var lets bytes.Buffer
+	for i, name := range names {
+		fmt.Fprintf(&lets, "let %s = %d; ", name, i)
+	}
+	list := strings.Join(names, ", ")
+
+	tests := []vmTestCase{
+		// 300 locals
+		{"let f = fn() { " + lets.String() + names[299] + " - " + names[0] + " }; f()", 299},
+		// 300 parameters and arguments
+		{"let f = fn(" + list + ") { " + names[299] + " }; f(" + strings.Join(numbers[:300], ", ") + ")", 299},
+		// 300 free variables
+		{"let f = fn() { " + lets.String() + "fn() { [" + list + "][299] } }; f()()", 299},
+		// 70000 constants in an array literal of 70000 elements
+		{"let a = [" + strings.Join(numbers, ", ") + "]; a[69999] + len(a)", 139999},
+		// 80000 keys and values in a hash literal
+		{"let h = {" + strings.Join(pairs, ", ") + "}; h[39999]", 39999},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input  string
+		limits Limits
+	}{
+		{`let f = fn() { f() }; f()`, Limits{}},
+		{`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(100)`, Limits{MaxFrames: 50}},
+		{`let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(100)`, Limits{MaxStackSize: 50}},
+		{`let f = fn() { let a = 1; let b = 2; let c = 3; [a, b, c] }; f()`, Limits{MaxStackSize: 3}},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		vm.SetLimits(tt.limits)
+		err = vm.Run()
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || err.Error() != "stack overflow" {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, "stack overflow", err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	comp := compiler.New()
+	err := comp.Compile(parse(`let f = fn() { while (true) { } }; f()`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	vm := New(comp.Bytecode())
+	err = vm.RunContext(ctx)
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error: %v", err)
+	}
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Frames[0].Function != "f" {
+		t.Errorf("wrong traceback: %v", err)
+	}
+
+	// a canceled context stops the VM before it starts
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	vm = New(comp.Bytecode())
+	err = vm.RunContext(ctx)
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error: %v", err)
+	}
+	if err.Error() != "execution canceled: context canceled" {
+		t.Errorf("wrong message: %q", err)
+	}
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"2.5", 2.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2.0},
+		{"7 / 2.0", 3.5},
+		{"1e3 - 1", 999.0},
+		{"1.5 < 2", true},
+		{"1.5 > 2", false},
+		{"2 == 2.0", true},
+		{"2 != 2.5", true},
+		{"float(3)", 3.0},
+		{"int(3.9)", 3},
+		{"floor(-1.5)", -2.0},
+		{"ceil(1.2)", 2.0},
+		{"sqrt(16)", 4.0},
+		{"abs(-1.25)", 1.25},
+		{"abs(-3)", 3},
 	}
 
 	runVmTests(t, tests)
@@ -468,6 +1268,12 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			t.Fatalf("compiler error: %s", err)
 		}
 
+		// everything the compiler produces must pass the verifier
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("verifier error: %s", err)
+		}
+
 		vm := New(comp.Bytecode())
 		err = vm.Run()
 		if err != nil {
@@ -499,6 +1305,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("testIntegerObject failed: %s", err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("testFloatObject failed: %s", err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -540,14 +1351,14 @@ func testExpectedObject(
 			t.Errorf("object is not Hash. got=%T(%+v)", actual, actual)
 		}
 
-		if len(hash.Pairs) != len(expected) {
-			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), len(hash.Pairs))
+		if hash.Len() != len(expected) {
+			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d", len(expected), hash.Len())
 		}
 
-		for ek, ev := range expected {
-			pair, ok := hash.Pairs[ek]
+		for _, pair := range hash.Pairs() {
+			ev, ok := expected[pair.Key.(object.Hashable).HashKey()]
 			if !ok {
-				t.Errorf("no pair for given key in pairs")
+				t.Errorf("unexpected key in pairs: %s", pair.Key.Inspect())
 			}
 
 			err := testIntegerObject(ev, pair.Value)
@@ -592,6 +1403,21 @@ func testBooleanObject(expected bool, actual object.Object) error {
 	return nil
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+
+	return nil
+}
+
 func testIntegerObject(expected int64, actual object.Object) error {
 	result, ok := actual.(*object.Integer)
 	if !ok {